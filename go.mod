@@ -4,7 +4,7 @@ go 1.15
 
 require (
 	github.com/golang/protobuf v1.5.2
-	github.com/google/uuid v1.2.0 // indirect
+	github.com/google/uuid v1.2.0
 	github.com/micro/micro/v3 v3.10.0
 	github.com/micro/services v0.25.0
 	google.golang.org/genproto v0.0.0-20220310185008-1973136f34c6 // indirect
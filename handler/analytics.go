@@ -2,11 +2,13 @@ package handler
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/micro/micro/v3/service/config"
 	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
 	"github.com/micro/micro/v3/service/store"
 	"github.com/micro/services/pkg/tenant"
 
@@ -15,12 +17,81 @@ import (
 
 // Analytics implements the notes proto definition
 type Analytics struct {
+	// shard identifies the records written by this replica. Every replica
+	// counts into its own records which are summed when read, so replicas
+	// never overwrite each other's increments. The shards of replicas which
+	// stopped are compacted, so the number read doesn't grow with every deploy.
+	shard string
+	// lock serialises the read-modify-write of the shard's records
 	lock sync.Mutex
+	// timestamps decides when the events clients supply timestamps for happened
+	timestamps timestampPolicy
+	// eventLog is set to keep every tracked event in the event log
+	eventLog bool
+	// watchers are the Watch streams waiting for changes
+	watchers  map[*watcher]bool
+	watchLock sync.RWMutex
+	// sessionTimeout is the inactivity which ends the session of a user
+	sessionTimeout time.Duration
+	// bots classifies the clients tracking events as bots
+	bots *botFilter
+	// geoIP locates the ip addresses events are tracked with, if set
+	geoIP *GeoIP
+}
+
+// Option configures Analytics
+type Option func(*Analytics)
+
+// WithGeoIP records the country, region and city of the ip addresses
+// events are tracked with
+func WithGeoIP(g *GeoIP) Option {
+	return func(a *Analytics) {
+		a.geoIP = g
+	}
+}
+
+// WithReplica sets the id of the replica, which names the shard its
+// records are counted into
+func WithReplica(id string) Option {
+	return func(a *Analytics) {
+		a.shard = id
+	}
+}
+
+// replicaID returns the configured id of the replica, which must be unique
+// e.g the ordinal of a StatefulSet, or a random id otherwise. The shards of
+// replicas which stopped are folded into a live one by CompactShards.
+func replicaID() string {
+	if v, err := config.Get("analytics.replica_id"); err == nil {
+		if id := v.String(""); len(id) > 0 {
+			return id
+		}
+	}
+	return uuid.New().String()
 }
 
 // New returns an initialized Analytics
-func New() *Analytics {
-	return &Analytics{}
+func New(opts ...Option) *Analytics {
+	a := &Analytics{
+		shard:          replicaID(),
+		timestamps:     loadTimestampPolicy(),
+		bots:           loadBotFilter(),
+		watchers:       map[*watcher]bool{},
+		sessionTimeout: 30 * time.Minute,
+	}
+
+	if v, err := config.Get("analytics.event_log"); err == nil {
+		a.eventLog = v.Bool(false)
+	}
+	if v, err := config.Get("analytics.session_timeout"); err == nil {
+		a.sessionTimeout = v.Duration(a.sessionTimeout)
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a
 }
 
 // Track inserts a new Event in the store
@@ -29,48 +100,171 @@ func (a *Analytics) Track(ctx context.Context, req *pb.TrackRequest, rsp *pb.Tra
 	if len(req.Name) == 0 {
 		return errors.BadRequest("analytics.track", "missing name")
 	}
+	if !finite(req.Amount) {
+		return errors.BadRequest("analytics.track", "amount must be a finite number")
+	}
 
+	t, late, err := a.timestamps.resolve(req.Timestamp, time.Now().UTC())
+	if err != nil {
+		return errors.BadRequest("analytics.track", err.Error())
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	// Record the event before responding if asked to
+	if req.Sync {
+		event, err := a.track(tnt, req, t, late)
+		if err != nil {
+			return errors.InternalServerError("analytics.track", "Error tracking event: %v", err.Error())
+		}
+		// only the events of bots which are dropped aren't recorded
+		rsp.Event = event
+		rsp.Filtered = event == nil
+		return nil
+	}
+
+	// Otherwise record it once the request is done
 	defer func() {
-		a.lock.Lock()
-		defer a.lock.Unlock()
-		tnt, ok := tenant.FromContext(ctx)
-		if !ok {
-			tnt = "default"
+		if _, err := a.track(tnt, req, t, late); err != nil {
+			logger.Errorf("Error tracking event %s: %v", req.Name, err)
+		}
+	}()
+
+	return nil
+}
+
+// track counts the event, which happened at t, in the store and returns
+// its updated value, or nil if it was dropped as bot traffic
+func (a *Analytics) track(tnt string, req *pb.TrackRequest, t time.Time, late bool) (*pb.Event, error) {
+	item := &pb.TrackItem{
+		Name:       req.Name,
+		Amount:     req.Amount,
+		Properties: req.Properties,
+		DistinctId: req.DistinctId,
+		UserAgent:  req.UserAgent,
+		Ip:         req.Ip,
+	}
+
+	b := a.newBatch()
+
+	policy := a.bots.filter(tnt, item)
+	if len(policy) > 0 {
+		b.filtered(0, tnt, item, t, policy)
+	}
+
+	a.locate(item)
+
+	if policy != botDrop {
+		b.track(0, tnt, item, t, late, nil)
+		if a.eventLog {
+			b.log(0, tnt, item, t, late, nil)
+		}
+	}
+
+	a.lock.Lock()
+	errs := b.commit()
+	a.lock.Unlock()
+
+	if err := errs[0]; err != nil {
+		return nil, err
+	}
+
+	// bots don't change the event
+	if policy == botDrop {
+		return nil, nil
+	}
+
+	a.notify(tnt, req.Name)
+
+	// read back the total of every replica
+	return readEvent(tnt, req.Name)
+}
+
+// eventKey returns the store prefix of the lifetime value of an Event
+func eventKey(tnt, name string) string {
+	return keyPrefix(kindEvent, tnt, name)
+}
+
+// readEvent returns the lifetime value of an Event summed across shards
+func readEvent(tnt, name string) (*pb.Event, error) {
+	records, err := readRecords(eventKey(tnt, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, store.ErrNotFound
+	}
+
+	total := newRecord(&pb.Event{Name: name})
+	for _, r := range records {
+		total.merge(r)
+	}
+
+	return total.Event, nil
+}
+
+// parseRange returns the RFC3339 start and end of a request to an endpoint,
+// both rounded by round if set. The end defaults to now and the start to
+// span before the end.
+func parseRange(id, start, end string, span time.Duration, round func(time.Time) time.Time) (time.Time, time.Time, error) {
+	if round == nil {
+		round = func(t time.Time) time.Time { return t }
+	}
+
+	e := time.Now().UTC()
+	if len(end) > 0 {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.BadRequest(id, "invalid end")
 		}
+		e = t.UTC()
+	}
+	e = round(e)
 
-		key := fmt.Sprintf("%s:%s", tnt, req.Name)
-
-		var event *pb.Event
-
-		// Create new Event if it doesn't exist or increment the value if it exists
-		recs, err := store.Read(key)
-		if err == store.ErrNotFound {
-			t := time.Now().Format(time.RFC3339)
-			event = &pb.Event{
-				Name:    req.Name,
-				Created: t,
-				Value:   1,
-			}
-		} else if err == nil {
-			if err := recs[0].Decode(&event); err != nil {
-				return
-			}
-			event.Value = event.Value + 1
-		} else {
-			return
+	s := e.Add(-span)
+	if len(start) > 0 {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.BadRequest(id, "invalid start")
 		}
+		s = round(t.UTC())
+	}
 
-		// write Event data to store
-		rec := store.NewRecord(key, event)
+	if s.After(e) {
+		return time.Time{}, time.Time{}, errors.BadRequest(id, "start must be before end")
+	}
 
-		if err = store.Write(rec); err != nil {
-			return
+	return s, e, nil
+}
+
+// deleteEvents removes the records stored under prefix
+func deleteEvents(prefix string) error {
+	recs, err := store.Read(prefix, store.ReadPrefix())
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		if err := store.Delete(rec.Key); err != nil && err != store.ErrNotFound {
+			return err
 		}
-	}()
+	}
 
 	return nil
 }
 
+// relatedPrefixes returns the prefixes of the records kept alongside an Event
+func relatedPrefixes(tnt, name string) []string {
+	var prefixes []string
+	for _, g := range granularities {
+		prefixes = append(prefixes, bucketPrefix(tnt, name, g))
+	}
+	return append(prefixes, dimensionPrefix(tnt, name), enrichmentPrefix(tnt, name), activityPrefix(tnt, name), topValuesPrefix(tnt, name))
+}
+
 // Get returns a single Event
 func (a *Analytics) Read(ctx context.Context, req *pb.ReadRequest, rsp *pb.ReadResponse) error {
 	// Validate the request
@@ -83,22 +277,14 @@ func (a *Analytics) Read(ctx context.Context, req *pb.ReadRequest, rsp *pb.ReadR
 		tnt = "default"
 	}
 
-	key := fmt.Sprintf("%s:%s", tnt, req.Name)
-
 	// Get the Event from the store
-	recs, err := store.Read(key)
+	event, err := readEvent(tnt, req.Name)
 	if err == store.ErrNotFound {
 		return errors.NotFound("analytics.get", "Event not found")
 	} else if err != nil {
 		return errors.InternalServerError("analytics.get", "Error reading from store: %v", err.Error())
 	}
 
-	// Decode the Event
-	var event *pb.Event
-	if err := recs[0].Decode(&event); err != nil {
-		return errors.InternalServerError("analytics.get", "Error unmarshaling JSON: %v", err.Error())
-	}
-
 	rsp.Event = event
 
 	return nil
@@ -116,25 +302,29 @@ func (a *Analytics) Delete(ctx context.Context, req *pb.DeleteRequest, rsp *pb.D
 		tnt = "default"
 	}
 
-	key := fmt.Sprintf("%s:%s", tnt, req.Name)
-
 	// Get the Event from the store
-	recs, err := store.Read(key)
+	event, err := readEvent(tnt, req.Name)
 	if err == store.ErrNotFound {
 		return errors.NotFound("analytics.delete", "Event not found")
 	} else if err != nil {
 		return errors.InternalServerError("analytics.delete", "Error reading from store: %v", err.Error())
 	}
 
-	// Decode the Event
-	var event *pb.Event
-	if err := recs[0].Decode(&event); err != nil {
-		return errors.InternalServerError("analytics.delete", "Error unmarshaling JSON: %v", err.Error())
+	// now delete every shard of it
+	if err := deleteEvents(eventKey(tnt, req.Name)); err != nil {
+		return errors.InternalServerError("analytics.delete", "Failed to delete event")
 	}
 
-	// now delete it
-	if err := store.Delete(key); err != nil && err != store.ErrNotFound {
-		return errors.InternalServerError("analytics.delete", "Failed to delete event")
+	// and its history and breakdowns
+	for _, prefix := range relatedPrefixes(tnt, req.Name) {
+		if err := deleteEvents(prefix); err != nil {
+			return errors.InternalServerError("analytics.delete", "Failed to delete event history")
+		}
+	}
+
+	// and its type, so it can be declared again
+	if err := store.Delete(typeKey(tnt, req.Name)); err != nil && err != store.ErrNotFound {
+		return errors.InternalServerError("analytics.delete", "Failed to delete event type")
 	}
 
 	rsp.Event = event
@@ -142,29 +332,54 @@ func (a *Analytics) Delete(ctx context.Context, req *pb.DeleteRequest, rsp *pb.D
 	return nil
 }
 
-// List returns all of the Events in the store
+// List returns the Events in the store a page at a time
 func (a *Analytics) List(ctx context.Context, req *pb.ListRequest, rsp *pb.ListResponse) error {
+	// Validate the request
+	switch req.SortBy {
+	case "", "name", "value", "created":
+	default:
+		return errors.BadRequest("analytics.list", "invalid sort_by")
+	}
+
+	switch req.Order {
+	case "", "asc", "desc":
+	default:
+		return errors.BadRequest("analytics.list", "invalid order")
+	}
+
+	if req.Limit == 0 {
+		req.Limit = defaultListLimit
+	}
+	if req.Limit > maxListLimit {
+		return errors.BadRequest("analytics.list", "limit exceeds %d", maxListLimit)
+	}
+
+	after, err := decodePageToken(req.PageToken)
+	if err != nil {
+		return errors.BadRequest("analytics.list", "invalid page_token")
+	}
+
 	tnt, ok := tenant.FromContext(ctx)
 	if !ok {
 		tnt = "default"
 	}
 
-	// Read all events from the store
-	recs, err := store.Read(tnt, store.ReadPrefix())
+	// Events sorted by name only need their keys listed, anything else
+	// needs every event read to sort them. Pages start after the last
+	// event of the previous one, so events created in between are
+	// neither skipped nor repeated.
+	var next *pb.Event
+	if req.SortBy == "" || req.SortBy == "name" {
+		rsp.Events, next, err = listByName(tnt, req, after)
+	} else {
+		rsp.Events, next, err = listSorted(tnt, req, after)
+	}
 	if err != nil {
 		return errors.InternalServerError("analytics.list", "Error reading from store: %v", err.Error())
 	}
 
-	// Initialize the response events slice
-	rsp.Events = make([]*pb.Event, len(recs))
-
-	// Retrieve all of the records in the store
-	for i, rec := range recs {
-
-		// Unmarshal the events into the response
-		if err := rec.Decode(&rsp.Events[i]); err != nil {
-			return errors.InternalServerError("analytics.list", "Error decoding event: %v", err.Error())
-		}
+	if next != nil {
+		rsp.NextPageToken = encodePageToken(next)
 	}
 
 	return nil
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/config/env"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/micro/v3/service/store/memory"
+
+	pb "analytics/proto"
+)
+
+// slowStore widens the gap between reading and writing a record, so
+// replicas updating the same record would lose increments
+type slowStore struct {
+	store.Store
+}
+
+func (s *slowStore) Read(key string, opts ...store.ReadOption) ([]*store.Record, error) {
+	recs, err := s.Store.Read(key, opts...)
+	time.Sleep(time.Millisecond)
+	return recs, err
+}
+
+func TestConcurrentTrack(t *testing.T) {
+	store.DefaultStore = &slowStore{memory.NewStore()}
+	config.DefaultConfig, _ = env.NewConfig()
+
+	// every handler acts as a separate replica sharing the same store
+	replicas := []*Analytics{New(WithReplica("a")), New(WithReplica("b")), New(WithReplica("c"))}
+	perReplica := 50
+
+	var wg sync.WaitGroup
+	for _, a := range replicas {
+		for i := 0; i < perReplica; i++ {
+			wg.Add(1)
+			go func(a *Analytics, i int) {
+				defer wg.Done()
+				req := &pb.TrackRequest{
+					Name:       "click",
+					Amount:     2,
+					Properties: map[string]string{"page": "/pricing"},
+					Sync:       true,
+				}
+				if err := a.Track(context.Background(), req, &pb.TrackResponse{}); err != nil {
+					t.Errorf("Error tracking event: %v", err)
+				}
+			}(a, i)
+		}
+	}
+	wg.Wait()
+
+	want := uint64(len(replicas) * perReplica)
+
+	// every replica reads the same total
+	for _, a := range replicas {
+		rsp := &pb.ReadResponse{}
+		if err := a.Read(context.Background(), &pb.ReadRequest{Name: "click"}, rsp); err != nil {
+			t.Fatalf("Error reading event: %v", err)
+		}
+		if rsp.Event.Value != want {
+			t.Errorf("Expected value %d, got %d", want, rsp.Event.Value)
+		}
+		if rsp.Event.Sum != float64(2*want) {
+			t.Errorf("Expected sum %d, got %v", 2*want, rsp.Event.Sum)
+		}
+	}
+
+	list := &pb.ListResponse{}
+	if err := replicas[0].List(context.Background(), &pb.ListRequest{}, list); err != nil {
+		t.Fatalf("Error listing events: %v", err)
+	}
+	if len(list.Events) != 1 || list.Events[0].Value != want {
+		t.Errorf("Expected a single event with value %d, got %v", want, list.Events)
+	}
+
+	series := &pb.SeriesResponse{}
+	if err := replicas[1].Series(context.Background(), &pb.SeriesRequest{Name: "click", Granularity: "day"}, series); err != nil {
+		t.Fatalf("Error reading series: %v", err)
+	}
+	if last := series.Points[len(series.Points)-1]; last.Value != want {
+		t.Errorf("Expected the current bucket to be %d, got %d", want, last.Value)
+	}
+
+	breakdown := &pb.BreakdownResponse{}
+	if err := replicas[2].Breakdown(context.Background(), &pb.BreakdownRequest{Name: "click", Keys: []string{"page"}}, breakdown); err != nil {
+		t.Fatalf("Error reading breakdown: %v", err)
+	}
+	if len(breakdown.Groups) != 1 || breakdown.Groups[0].Value != want {
+		t.Errorf("Expected a single group with value %d, got %v", want, breakdown.Groups)
+	}
+}
+
+func TestListByName(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	a := New()
+	track := func(name string) {
+		if err := a.Track(context.Background(), &pb.TrackRequest{Name: name, Sync: true}, &pb.TrackResponse{}); err != nil {
+			t.Fatalf("Error tracking event: %v", err)
+		}
+	}
+	for _, name := range []string{"view", "click", "click.x", "click-y", "a b"} {
+		track(name)
+	}
+
+	var names []string
+	req := &pb.ListRequest{Limit: 2}
+
+	for {
+		rsp := &pb.ListResponse{}
+		if err := a.List(context.Background(), req, rsp); err != nil {
+			t.Fatalf("Error listing events: %v", err)
+		}
+		for _, e := range rsp.Events {
+			names = append(names, e.Name)
+		}
+		if len(rsp.NextPageToken) == 0 {
+			break
+		}
+		req.PageToken = rsp.NextPageToken
+
+		// events created between pages are listed if they sort later
+		if len(names) == 2 {
+			track("b")
+			track("click.z")
+		}
+	}
+
+	want := []string{"a b", "click", "click-y", "click.x", "click.z", "view"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+}
+
+func TestReadTotalsTruncated(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	for _, id := range []string{"a", "b", "c"} {
+		a := New(WithReplica(id))
+		for _, name := range []string{"click", "view"} {
+			if err := a.Track(context.Background(), &pb.TrackRequest{Name: name, Sync: true}, &pb.TrackResponse{}); err != nil {
+				t.Fatalf("Error tracking event: %v", err)
+			}
+		}
+	}
+
+	// the limit ends within the shards of view, which is left out
+	totals, truncated, err := readTotals(kindEvent, 4)
+	if err != nil {
+		t.Fatalf("Error reading totals: %v", err)
+	}
+	if len(totals["default"]) != 1 || totals["default"]["click"].Value != 3 {
+		t.Errorf("Expected only click with value 3, got %v", totals["default"])
+	}
+	if truncated["default"] != 3 {
+		t.Errorf("Expected 3 records left out, got %d", truncated["default"])
+	}
+}
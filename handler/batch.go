@@ -0,0 +1,376 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// maxBatch is the maximum number of events accepted by BatchTrack
+const maxBatch = 1000
+
+// batches counts the batches started by this replica
+var batches uint64
+
+// change is a pending read-modify-write of the record at a key, which
+// combines the changes of every item written to the key
+type change interface {
+	// apply reads the record at key, changes it and writes it back
+	apply(key string) error
+}
+
+// update is a pending change to the Event stored at a key
+type update struct {
+	// event is written if the key doesn't exist yet
+	event *pb.Event
+	// delta holds the counts and sketches to add
+	delta  *record
+	expiry time.Duration
+}
+
+// apply counts the delta into the Event stored at key
+func (u *update) apply(key string) error {
+	var r *record
+
+	recs, err := store.Read(key)
+	if err == store.ErrNotFound {
+		r = newRecord(u.event)
+	} else if err != nil {
+		return err
+	} else if r, err = decodeRecord(recs[0]); err != nil {
+		return err
+	}
+
+	// events tracked with an earlier timestamp move the creation back
+	if u.event.Created < r.Created {
+		r.Created = u.event.Created
+	}
+
+	r.merge(u.delta)
+
+	// write Event data to store
+	rec := &store.Record{Key: key, Expiry: u.expiry}
+	if err := rec.Encode(r); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// measurement is how an amount which isn't tracked by Track is counted,
+// e.g the value of a StatsD metric
+type measurement struct {
+	// Type counts the amount as the type of the metric, unless the event
+	// is declared with another
+	Type string `json:"type,omitempty"`
+	// Weight is the number of events the amount was sampled from, if more than 1
+	Weight uint64 `json:"weight,omitempty"`
+}
+
+// batch coalesces the changes of tracked events so every key is written once
+type batch struct {
+	// id orders the batch in the event log
+	id string
+	// shard the records are written to
+	shard string
+	// keys in the order they were first changed
+	keys    []string
+	changes map[string]change
+	// items which contributed to the change of every key
+	items map[string][]int
+	// sessionTimeout is the inactivity which ends the session of a user
+	sessionTimeout time.Duration
+	// types of the events in the batch, by their type key
+	types map[string]*eventType
+}
+
+func newBatch(shard string, sessionTimeout time.Duration) *batch {
+	return &batch{
+		id:             fmt.Sprintf("%020d-%d", time.Now().UnixNano(), atomic.AddUint64(&batches, 1)),
+		shard:          shard,
+		changes:        map[string]change{},
+		items:          map[string][]int{},
+		sessionTimeout: sessionTimeout,
+		types:          map[string]*eventType{},
+	}
+}
+
+// newBatch starts a batch of the replica's changes
+func (a *Analytics) newBatch() *batch {
+	return newBatch(a.shard, a.sessionTimeout)
+}
+
+// pending returns the change of the shard's record at key on behalf of
+// item i, starting a new one with create if there's none yet
+func (b *batch) pending(i int, key string, create func() change) change {
+	key = shardKey(key, b.shard)
+
+	c, ok := b.changes[key]
+	if !ok {
+		c = create()
+		b.changes[key] = c
+		b.keys = append(b.keys, key)
+	}
+
+	b.items[key] = append(b.items[key], i)
+
+	return c
+}
+
+// add counts delta into the Event stored at key on behalf of item i,
+// creating it from ev if it doesn't exist
+func (b *batch) add(i int, key string, ev *pb.Event, delta *record, expiry time.Duration) {
+	u := b.pending(i, key, func() change {
+		return &update{
+			event:  ev,
+			delta:  newRecord(&pb.Event{}),
+			expiry: expiry,
+		}
+	}).(*update)
+
+	if ev.Created < u.event.Created {
+		u.event.Created = ev.Created
+	}
+
+	u.delta.merge(delta)
+}
+
+// track adds every record counting item i, which happened at t, as
+// measured by m if set. Late items are only counted in the records which
+// aren't bucketed by time.
+func (b *batch) track(i int, tnt string, item *pb.TrackItem, t time.Time, late bool, m *measurement) {
+	typ := b.typeOf(tnt, item.Name)
+
+	weight := uint64(1)
+	if m != nil {
+		if typ == counter && len(m.Type) > 0 {
+			typ = &eventType{Type: m.Type}
+		}
+		if m.Weight > 1 {
+			weight = m.Weight
+		}
+	}
+
+	// the amounts of gauges and histograms are values, which may be 0
+	amount := item.Amount
+	if amount == 0 && typ.Type == typeCounter {
+		amount = 1
+	}
+
+	created := t.Format(time.RFC3339)
+
+	// the browser, os and device become properties unless set explicitly
+	props := item.Properties
+	if len(item.UserAgent) > 0 {
+		props = parseUserAgent(item.UserAgent).properties()
+		for k, v := range item.Properties {
+			props[k] = v
+		}
+	}
+
+	// what the item adds to every record counting it
+	delta := newRecord(&pb.Event{
+		Type:  typ.Type,
+		Value: weight,
+		Sum:   amount * float64(weight),
+		Min:   amount,
+		Max:   amount,
+	})
+	if len(item.DistinctId) > 0 {
+		delta.Sketch = newHLL()
+		delta.Sketch.add(item.DistinctId)
+	}
+	if late {
+		delta.Late = 1
+	}
+	typ.sample(delta, amount, weight, t.UnixNano())
+
+	// the lifetime value of the event
+	b.add(i, eventKey(tnt, item.Name), &pb.Event{
+		Name:    item.Name,
+		Created: created,
+	}, delta, 0)
+
+	// the count of the property values, those recorded from the user
+	// agent and ip address each counted on their own and those declared
+	// with top values estimated instead
+	dims := map[string]string{}
+	for k, v := range props {
+		switch {
+		case typ.hasTopValues(k):
+			c := b.pending(i, topValuesKey(tnt, item.Name, k), func() change {
+				return &countValues{values: map[string]uint64{}}
+			}).(*countValues)
+			c.values[v]++
+		case enrichmentKeys[k]:
+			b.add(i, enrichmentKey(tnt, item.Name, k, v), &pb.Event{
+				Name:       item.Name,
+				Created:    created,
+				Properties: map[string]string{k: v},
+			}, delta, 0)
+		default:
+			dims[k] = v
+		}
+	}
+	b.add(i, dimensionKey(tnt, item.Name, dims), &pb.Event{
+		Name:       item.Name,
+		Created:    created,
+		Properties: dims,
+	}, delta, 0)
+
+	if late {
+		return
+	}
+
+	// the time buckets keep a sketch of the amounts tracked explicitly, so
+	// their quantiles can be merged over any range
+	bucketDelta := delta
+	if delta.Digest == nil && (item.Amount != 0 || typ.Type != typeCounter) {
+		withDigest := *delta
+		withDigest.Digest = newDDSketch()
+		withDigest.Digest.add(amount, weight)
+		bucketDelta = &withDigest
+	}
+
+	// the time buckets the event falls into
+	for _, g := range granularities {
+		start := t.Truncate(g.size)
+		b.add(i, bucketKey(tnt, item.Name, g, start), &pb.Event{
+			Name:    item.Name,
+			Created: start.Format(time.RFC3339),
+		}, bucketDelta, g.expiry)
+	}
+
+	// the events of the user, followed through funnels
+	if len(item.DistinctId) > 0 {
+		key := historyKey(tnt, t.Truncate(24*time.Hour), item.DistinctId)
+		h := b.pending(i, key, func() change {
+			return &appendHistory{id: item.DistinctId}
+		}).(*appendHistory)
+		h.events = append(h.events, &occurrence{Name: item.Name, Time: t.Unix()})
+
+		// the users active on the day, followed through cohorts
+		x := hash(item.DistinctId)
+		key = activityPartitionKey(tnt, item.Name, t, x)
+		u := b.pending(i, key, func() change {
+			return &addActivity{ids: newIDSet()}
+		}).(*addActivity)
+		u.ids.add(x)
+
+		// the session of the user
+		s := b.pending(i, sessionKey(tnt, item.DistinctId), func() change {
+			return &trackSession{
+				id:      item.DistinctId,
+				timeout: b.sessionTimeout,
+			}
+		}).(*trackSession)
+		s.events = append(s.events, &occurrence{Name: item.Name, Time: t.Unix()})
+	}
+}
+
+// commit applies every change and returns the errors of the items which
+// couldn't be recorded
+func (b *batch) commit() map[int]error {
+	errs := map[int]error{}
+
+	for _, key := range b.keys {
+		if err := b.changes[key].apply(key); err != nil {
+			for _, i := range b.items[key] {
+				errs[i] = err
+			}
+		}
+	}
+
+	return errs
+}
+
+// BatchTrack records many events at once
+func (a *Analytics) BatchTrack(ctx context.Context, req *pb.BatchTrackRequest, rsp *pb.BatchTrackResponse) error {
+	// Validate the request
+	if len(req.Events) == 0 {
+		return errors.BadRequest("analytics.batchtrack", "missing events")
+	}
+	if len(req.Events) > maxBatch {
+		return errors.BadRequest("analytics.batchtrack", "too many events, at most %d are allowed", maxBatch)
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	rsp.Results = make([]*pb.TrackResult, len(req.Events))
+
+	now := time.Now().UTC()
+	b := a.newBatch()
+	// items dropped as bot traffic
+	dropped := map[int]bool{}
+
+	for i, item := range req.Events {
+		if len(item.Name) == 0 {
+			rsp.Results[i] = &pb.TrackResult{Error: "missing name"}
+			continue
+		}
+		if !finite(item.Amount) {
+			rsp.Results[i] = &pb.TrackResult{Error: "amount must be a finite number"}
+			continue
+		}
+
+		t, late, err := a.timestamps.resolve(item.Timestamp, now)
+		if err != nil {
+			rsp.Results[i] = &pb.TrackResult{Error: err.Error()}
+			continue
+		}
+
+		policy := a.bots.filter(tnt, item)
+		if len(policy) > 0 {
+			b.filtered(i, tnt, item, t, policy)
+		}
+
+		a.locate(item)
+
+		if policy == botDrop {
+			dropped[i] = true
+			continue
+		}
+
+		b.track(i, tnt, item, t, late, nil)
+		if a.eventLog {
+			b.log(i, tnt, item, t, late, nil)
+		}
+	}
+
+	a.lock.Lock()
+	errs := b.commit()
+	a.lock.Unlock()
+
+	var changed []string
+
+	for i, res := range rsp.Results {
+		// the item was invalid
+		if res != nil {
+			continue
+		}
+		if err, ok := errs[i]; ok {
+			rsp.Results[i] = &pb.TrackResult{Error: fmt.Sprintf("Error writing to store: %v", err)}
+			continue
+		}
+		if dropped[i] {
+			rsp.Results[i] = &pb.TrackResult{Success: true, Filtered: true}
+			continue
+		}
+		rsp.Results[i] = &pb.TrackResult{Success: true}
+		changed = append(changed, req.Events[i].Name)
+	}
+
+	a.notify(tnt, changed...)
+
+	return nil
+}
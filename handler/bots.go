@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// policies for the events classified as bots
+const (
+	// botAllow counts bots like any other client
+	botAllow = "allow"
+	// botDrop doesn't count the events of bots
+	botDrop = "drop"
+	// botCount counts the events of bots with the bot property
+	botCount = "count"
+)
+
+// botProperty is the dimension the events of bots are counted in
+const botProperty = "bot"
+
+// botPatterns match the user agents of crawlers, uptime checkers and
+// scripts. More are added with analytics.bot_patterns.
+var botPatterns = []string{
+	`bot\b`, `crawl`, `spider`, `slurp`, `archiver`, `facebookexternalhit`,
+	`bingpreview`, `headless`, `phantomjs`, `lighthouse`, `prerender`,
+	`uptimerobot`, `pingdom`, `statuscake`, `site24x7`, `newrelicpinger`,
+	`datadog`, `betteruptime`, `^curl/`, `^wget/`,
+}
+
+// botHTTPClients match the user agents of HTTP client libraries, which
+// apps also send events with so they're only bots if analytics.bot_http_clients
+// is set. They only match the library's own user agent, not an app's
+// mentioning it.
+var botHTTPClients = []string{
+	`^python-`, `^go-http-client/`, `^java/`, `^okhttp/`,
+	`^apache-httpclient/`, `^axios/`, `^node-fetch`,
+}
+
+// botFilter classifies the clients tracking events as bots
+type botFilter struct {
+	// agents matches the user agents of bots
+	agents *regexp.Regexp
+	// networks are the ip ranges of bots e.g of a monitoring service
+	networks []*net.IPNet
+	// policy applied to the tenants without one of their own, bots are
+	// allowed unless tenants opt into dropping or counting them
+	policy string
+	// policies of tenants
+	policies map[string]string
+}
+
+// loadBotFilter reads the bot filter from the config
+func loadBotFilter() *botFilter {
+	f := &botFilter{
+		policy:   botAllow,
+		policies: map[string]string{},
+	}
+
+	patterns := botPatterns
+	if v, err := config.Get("analytics.bot_http_clients"); err == nil && v.Bool(false) {
+		patterns = append(patterns, botHTTPClients...)
+	}
+	if v, err := config.Get("analytics.bot_patterns"); err == nil {
+		patterns = append(patterns, v.StringSlice(nil)...)
+	}
+	agents, err := regexp.Compile(`(?i)` + strings.Join(patterns, "|"))
+	if err != nil {
+		logger.Fatalf("Invalid analytics.bot_patterns: %v", err)
+	}
+	f.agents = agents
+
+	if v, err := config.Get("analytics.bot_networks"); err == nil {
+		for _, cidr := range v.StringSlice(nil) {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				logger.Fatalf("Invalid analytics.bot_networks %q: %v", cidr, err)
+			}
+			f.networks = append(f.networks, n)
+		}
+	}
+
+	if v, err := config.Get("analytics.bot_policy"); err == nil {
+		f.policy = v.String(f.policy)
+	}
+	if v, err := config.Get("analytics.bot_policies"); err == nil {
+		f.policies = v.StringMap(f.policies)
+	}
+
+	for _, p := range append([]string{f.policy}, mapValues(f.policies)...) {
+		switch p {
+		case botAllow, botDrop, botCount:
+		default:
+			logger.Fatalf("Invalid bot policy %q, must be allow, drop or count", p)
+		}
+	}
+
+	return f
+}
+
+// mapValues returns the values of a map
+func mapValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+// classify returns the name of the bot which tracked item, if it's one
+func (f *botFilter) classify(item *pb.TrackItem) (string, bool) {
+	if len(item.UserAgent) > 0 && f.agents.MatchString(item.UserAgent) {
+		return botLabel(item.UserAgent), true
+	}
+
+	if ip := net.ParseIP(item.Ip); ip != nil {
+		for _, n := range f.networks {
+			if n.Contains(ip) {
+				return n.String(), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// filter applies the policy of a tenant to item, returning botDrop or
+// botCount if it was tracked by a bot. Counted items get the bot property.
+func (f *botFilter) filter(tnt string, item *pb.TrackItem) string {
+	policy, ok := f.policies[tnt]
+	if !ok {
+		policy = f.policy
+	}
+	if policy == botAllow {
+		return ""
+	}
+
+	name, ok := f.classify(item)
+	if !ok {
+		return ""
+	}
+
+	if policy == botCount {
+		props := map[string]string{botProperty: name}
+		for k, v := range item.Properties {
+			props[k] = v
+		}
+		item.Properties = props
+	}
+
+	return policy
+}
+
+// filteredKey returns the store prefix of the count of the events of bots
+// a policy was applied to
+func filteredKey(tnt, name, policy string) string {
+	return keyPrefix(kindFiltered, tnt, name, policy)
+}
+
+// filtered counts item i, which happened at t, as filtered by a policy
+func (b *batch) filtered(i int, tnt string, item *pb.TrackItem, t time.Time, policy string) {
+	b.add(i, filteredKey(tnt, item.Name, policy), &pb.Event{
+		Name:       item.Name,
+		Created:    t.Format(time.RFC3339),
+		Properties: map[string]string{"action": policy},
+	}, newRecord(&pb.Event{Value: 1, Sum: 1, Min: 1, Max: 1}), 0)
+}
+
+// Filtered returns the number of events classified as bots
+func (a *Analytics) Filtered(ctx context.Context, req *pb.FilteredRequest, rsp *pb.FilteredResponse) error {
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	records, err := readRecords(keyPrefix(kindFiltered, tnt))
+	if err != nil {
+		return errors.InternalServerError("analytics.filtered", "Error reading from store: %v", err.Error())
+	}
+
+	// sum the shards of every event and action
+	totals := map[string]*record{}
+	for _, r := range records {
+		addShard(totals, r.Name+separator+r.Properties["action"], r)
+	}
+
+	rsp.Events = []*pb.Event{}
+	for _, total := range totals {
+		rsp.Events = append(rsp.Events, total.Event)
+
+		switch total.Properties["action"] {
+		case botDrop:
+			rsp.Dropped += total.Value
+		case botCount:
+			rsp.Counted += total.Value
+		}
+	}
+
+	sort.Slice(rsp.Events, func(i, j int) bool {
+		if rsp.Events[i].Name != rsp.Events[j].Name {
+			return rsp.Events[i].Name < rsp.Events[j].Name
+		}
+		return rsp.Events[i].Properties["action"] < rsp.Events[j].Properties["action"]
+	})
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// enrichmentKeys are the properties recorded from the user agent and the
+// ip address. They're counted per property instead of in the combinations
+// of the other properties, which they would multiply.
+var enrichmentKeys = map[string]bool{
+	"browser":         true,
+	"browser_version": true,
+	"os":              true,
+	"os_version":      true,
+	"device":          true,
+	"country":         true,
+	"region":          true,
+	"city":            true,
+}
+
+// enrichmentPrefix returns the store prefix of the counts of the values of
+// the enrichment properties of an event, or of one of them
+func enrichmentPrefix(tnt, name string, key ...string) string {
+	return keyPrefix(kindEnrichment, append([]string{tnt, name}, key...)...)
+}
+
+// enrichmentKey returns the store prefix counting an event with a value of
+// an enrichment property
+func enrichmentKey(tnt, name, key, value string) string {
+	return keyPrefix(kindEnrichment, tnt, name, key, value)
+}
+
+// dimensionPrefix returns the store prefix of all the property counts of an event
+func dimensionPrefix(tnt, name string) string {
+	return keyPrefix(kindDimension, tnt, name)
+}
+
+// dimensionKey returns the store prefix counting an event with the given properties
+func dimensionKey(tnt, name string, props map[string]string) string {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// hash the sorted properties so every combination gets its own record
+	h := fnv.New64a()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(props[k]))
+		h.Write([]byte{0})
+	}
+
+	return keyPrefix(kindDimension, tnt, name, fmt.Sprintf("%016x", h.Sum64()))
+}
+
+// breakdown returns the counts of an Event grouped by the values of the
+// property keys, most frequent first. An enrichment property can only be
+// the single key.
+func breakdown(tnt, name string, keys []string) ([]*pb.Event, error) {
+	// Read the counts of every property combination, or of every value of
+	// an enrichment property
+	prefix := dimensionPrefix(tnt, name)
+	if len(keys) == 1 && enrichmentKeys[keys[0]] {
+		prefix = enrichmentPrefix(tnt, name, keys[0])
+	}
+
+	records, err := readRecords(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]*record{}
+	var events []*pb.Event
+
+	for _, r := range records {
+		// project the properties onto the requested keys
+		props := map[string]string{}
+		values := make([]string, len(keys))
+		for i, k := range keys {
+			v, ok := r.Properties[k]
+			if !ok {
+				continue
+			}
+			props[k] = v
+			values[i] = k + "=" + v
+		}
+
+		id := strings.Join(values, "\x00")
+		group, ok := groups[id]
+		if !ok {
+			group = newRecord(&pb.Event{
+				Name:       r.Name,
+				Properties: props,
+			})
+			groups[id] = group
+			events = append(events, group.Event)
+		}
+
+		group.merge(r)
+	}
+
+	// Most frequent groups first
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Value > events[j].Value
+	})
+
+	return events, nil
+}
+
+// Breakdown returns the counts of an Event grouped by property values
+func (a *Analytics) Breakdown(ctx context.Context, req *pb.BreakdownRequest, rsp *pb.BreakdownResponse) error {
+	// Validate the request
+	if len(req.Name) == 0 {
+		return errors.BadRequest("analytics.breakdown", "missing name")
+	}
+	if len(req.Keys) == 0 {
+		return errors.BadRequest("analytics.breakdown", "missing keys")
+	}
+	for _, k := range req.Keys {
+		if enrichmentKeys[k] && len(req.Keys) > 1 {
+			return errors.BadRequest("analytics.breakdown", "%s is recorded from the user agent or ip address, and can only be broken down on its own", k)
+		}
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	groups, err := breakdown(tnt, req.Name, req.Keys)
+	if err != nil {
+		return errors.InternalServerError("analytics.breakdown", "Error reading from store: %v", err.Error())
+	}
+
+	rsp.Groups = groups
+
+	return nil
+}
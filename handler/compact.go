@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+
+	pb "analytics/proto"
+)
+
+// compactedKey is the store key of the marker written once the shards of
+// replicas which ran before heartbeats were recorded have been compacted
+var compactedKey = keyPrefix(kindMigration) + "shards"
+
+// liveIntervals is the number of compaction intervals a replica may miss
+// its heartbeat for before its shards are folded into a live one
+const liveIntervals = 3
+
+// compactKinds are the kinds of records counted into a shard per replica
+var compactKinds = []string{
+	kindEvent,
+	kindSeries,
+	kindDimension,
+	kindEnrichment,
+	kindFiltered,
+	kindTopValues,
+	kindActivity,
+	kindHistory,
+	kindSessionStats,
+	kindSession,
+}
+
+// replicaKey returns the store key of the heartbeat of a replica
+func replicaKey(shard string) string {
+	return keyPrefix(kindReplica) + escape(shard)
+}
+
+// heartbeat is the last time a replica was seen
+type heartbeat struct {
+	Seen int64 `json:"seen"`
+}
+
+// Heartbeat records that the replica is live so its shards aren't compacted.
+// It must be called before the replica writes any record.
+func (a *Analytics) Heartbeat() error {
+	rec := &store.Record{Key: replicaKey(a.shard)}
+	if err := rec.Encode(&heartbeat{Seen: time.Now().Unix()}); err != nil {
+		return err
+	}
+	return store.Write(rec)
+}
+
+// CompactShards records the replica's heartbeat every interval and folds
+// the shards of the replicas which stopped into the shard of the live one
+// with the smallest id
+func (a *Analytics) CompactShards(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := a.Heartbeat(); err != nil {
+			logger.Errorf("Error recording heartbeat: %v", err)
+			continue
+		}
+		if err := a.compactShards(time.Now().Add(-liveIntervals * interval)); err != nil {
+			logger.Errorf("Error compacting shards: %v", err)
+		}
+	}
+}
+
+// readReplicas returns the shards of the replicas seen since live and the
+// keys of the heartbeats of the others
+func readReplicas(live time.Time) (map[string]bool, []string, error) {
+	recs, err := store.Read(keyPrefix(kindReplica), store.ReadPrefix())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shards := map[string]bool{}
+	var stale []string
+
+	for _, rec := range recs {
+		segments, ok := keySegments(kindReplica, rec.Key)
+		if !ok || len(segments) != 1 {
+			continue
+		}
+
+		var h heartbeat
+		if err := rec.Decode(&h); err != nil || h.Seen < live.Unix() {
+			stale = append(stale, rec.Key)
+			continue
+		}
+		shards[segments[0]] = true
+	}
+
+	return shards, stale, nil
+}
+
+// compactShards folds the records of every shard whose replica wasn't seen
+// since live into the replica's shard, if it's the live replica with the
+// smallest id. The shards of replicas without a heartbeat are only
+// compacted once, so replicas of older versions must be stopped first.
+func (a *Analytics) compactShards(live time.Time) error {
+	shards, stale, err := readReplicas(live)
+	if err != nil {
+		return err
+	}
+
+	// replicas which started since record their heartbeat before writing
+	// and stay live until the next round
+	ids := make([]string, 0, len(shards))
+	for id := range shards {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) == 0 || ids[0] != a.shard {
+		return nil
+	}
+
+	if len(stale) == 0 {
+		if _, err := store.Read(compactedKey); err == nil {
+			return nil
+		} else if err != store.ErrNotFound {
+			return err
+		}
+	}
+
+	var compacted int
+
+	for _, kind := range compactKinds {
+		keys, err := store.List(store.ListPrefix(keyPrefix(kind)))
+		if err != nil {
+			return err
+		}
+
+		// the replicas which wrote the keys listed recorded a heartbeat first
+		shards, _, err := readReplicas(live)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			segments, ok := keySegments(kind, key)
+			if !ok || len(segments) < 2 {
+				continue
+			}
+			if shard := segments[len(segments)-1]; shards[shard] || shard == a.shard {
+				continue
+			}
+
+			if err := a.compactKey(kind, key); err != nil {
+				return err
+			}
+			compacted++
+		}
+	}
+
+	if compacted > 0 {
+		logger.Infof("Compacted %d records of stopped replicas into %s", compacted, a.shard)
+	}
+
+	for _, key := range stale {
+		if err := store.Delete(key); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+
+	return store.Write(store.NewRecord(compactedKey, map[string]int{"compacted": compacted}))
+}
+
+// compactKey folds the record of a kind stored at key in the shard of a
+// replica which stopped into the replica's shard and deletes it
+func (a *Analytics) compactKey(kind, key string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	recs, err := store.Read(key)
+	if err == store.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	c, err := compaction(kind, recs[0])
+	if err != nil {
+		logger.Warnf("Deleting invalid record %s: %v", key, err)
+		return store.Delete(key)
+	}
+
+	if err := c.apply(shardKey(shardPrefix(key), a.shard)); err != nil {
+		return err
+	}
+
+	return store.Delete(key)
+}
+
+// compaction returns the change adding a record of a kind to another shard
+func compaction(kind string, rec *store.Record) (change, error) {
+	switch kind {
+	case kindTopValues:
+		h := newHeavyHitters()
+		if err := rec.Decode(h); err != nil {
+			return nil, err
+		}
+		return &mergeValues{hitters: h}, nil
+	case kindActivity:
+		ids := newIDSet()
+		if err := rec.Decode(ids); err != nil {
+			return nil, err
+		}
+		return &addActivity{ids: ids}, nil
+	case kindHistory:
+		var hist history
+		if err := rec.Decode(&hist); err != nil {
+			return nil, err
+		}
+		return &appendHistory{id: hist.DistinctId, events: hist.Events}, nil
+	case kindSessionStats:
+		stats := newSessionStats()
+		if err := rec.Decode(stats); err != nil {
+			return nil, err
+		}
+		return &addSessions{stats: stats}, nil
+	case kindSession:
+		parts, err := decodeSessionParts(rec)
+		if err != nil {
+			return nil, err
+		}
+		return &adoptSessions{parts: parts}, nil
+	}
+
+	r, err := decodeRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &update{
+		event: &pb.Event{
+			Name:       r.Name,
+			Created:    r.Created,
+			Properties: r.Properties,
+		},
+		delta:  r,
+		expiry: rec.Expiry,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/config/env"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/micro/v3/service/store/memory"
+
+	pb "analytics/proto"
+)
+
+func TestDefaultReplica(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	// replicas started without an id never share a shard
+	a, b := New(), New()
+	if len(a.shard) == 0 || a.shard == b.shard {
+		t.Errorf("Expected unique shards, got %q and %q", a.shard, b.shard)
+	}
+}
+
+func TestCompactShards(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	live, stopped := New(WithReplica("a")), New(WithReplica("b"))
+	for _, a := range []*Analytics{live, stopped} {
+		if err := a.Heartbeat(); err != nil {
+			t.Fatalf("Error recording heartbeat: %v", err)
+		}
+		for _, id := range []string{"alice", "bob"} {
+			req := &pb.TrackRequest{
+				Name:       "click",
+				DistinctId: id,
+				Properties: map[string]string{"page": "/pricing"},
+				Sync:       true,
+			}
+			if err := a.Track(context.Background(), req, &pb.TrackResponse{}); err != nil {
+				t.Fatalf("Error tracking event: %v", err)
+			}
+		}
+	}
+
+	// the stopped replica was last seen long ago
+	rec := &store.Record{Key: replicaKey(stopped.shard)}
+	if err := rec.Encode(&heartbeat{Seen: time.Now().Add(-time.Hour).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the live replica with the smallest id compacts
+	if err := stopped.compactShards(time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Error compacting shards: %v", err)
+	}
+	if err := live.compactShards(time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Error compacting shards: %v", err)
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if strings.HasSuffix(key, separator+stopped.shard) {
+			t.Errorf("Expected %s to be compacted", key)
+		}
+	}
+
+	rsp := &pb.ReadResponse{}
+	if err := live.Read(context.Background(), &pb.ReadRequest{Name: "click"}, rsp); err != nil {
+		t.Fatalf("Error reading event: %v", err)
+	}
+	if rsp.Event.Value != 4 || rsp.Event.Uniques != 2 {
+		t.Errorf("Expected value 4 and 2 uniques, got %d and %d", rsp.Event.Value, rsp.Event.Uniques)
+	}
+
+	series := &pb.SeriesResponse{}
+	if err := live.Series(context.Background(), &pb.SeriesRequest{Name: "click", Granularity: "day"}, series); err != nil {
+		t.Fatalf("Error reading series: %v", err)
+	}
+	if last := series.Points[len(series.Points)-1]; last.Value != 4 {
+		t.Errorf("Expected the current bucket to be 4, got %d", last.Value)
+	}
+
+	breakdown := &pb.BreakdownResponse{}
+	if err := live.Breakdown(context.Background(), &pb.BreakdownRequest{Name: "click", Keys: []string{"page"}}, breakdown); err != nil {
+		t.Fatalf("Error reading breakdown: %v", err)
+	}
+	if len(breakdown.Groups) != 1 || breakdown.Groups[0].Value != 4 {
+		t.Errorf("Expected a single group with value 4, got %v", breakdown.Groups)
+	}
+
+	// the parts of the sessions of the stopped replica are adopted
+	recs, err := store.Read(sessionKey("default", "alice"), store.ReadPrefix())
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("Expected the sessions of a single replica, got %v %v", recs, err)
+	}
+
+	// and merged with those of the live one when they end
+	now := time.Now().UTC()
+	if err := live.sweepSessions(now.Add(live.sessionTimeout + time.Minute)); err != nil {
+		t.Fatalf("Error sweeping sessions: %v", err)
+	}
+	stats, err := readSessionStats("default", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sessions != 2 || stats.Events != 4 {
+		t.Errorf("Expected 2 sessions of 4 events, got %d of %d", stats.Sessions, stats.Events)
+	}
+}
+
+// readSessionStats returns the statistics of the sessions which started
+// during a day, summed across shards
+func readSessionStats(tnt string, day time.Time) (*sessionStats, error) {
+	recs, err := store.Read(sessionStatsPrefix(tnt, day), store.ReadPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	total := newSessionStats()
+	for _, rec := range recs {
+		stats := newSessionStats()
+		if err := rec.Decode(stats); err != nil {
+			return nil, err
+		}
+		total.merge(stats)
+	}
+
+	return total, nil
+}
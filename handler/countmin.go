@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	// countMinWidth is the number of counters in every row of a countMin,
+	// which overestimates counts by at most e/width of the total
+	countMinWidth = 2048
+	// countMinDepth is the number of rows of a countMin, the overestimate
+	// is within the bound with a probability of 1 - e^-depth
+	countMinDepth = 5
+)
+
+// countMinError is the largest overestimate of a countMin as a fraction of the total
+var countMinError = math.E / countMinWidth
+
+// countMin is a Count-Min Sketch estimating how many times values were
+// counted in fixed space, never underestimating. Sketches are merged by
+// adding up their counters.
+type countMin struct {
+	counters [countMinDepth][countMinWidth]uint64
+}
+
+// indexes returns the counter of a value in every row, derived from two
+// halves of its hash
+func (c *countMin) indexes(v string) [countMinDepth]int {
+	x := hash(v)
+	h1, h2 := x&0xffffffff, x>>32|1
+
+	var idx [countMinDepth]int
+	for i := range idx {
+		idx[i] = int((h1 + uint64(i)*h2) % countMinWidth)
+	}
+	return idx
+}
+
+// add counts a value n times
+func (c *countMin) add(v string, n uint64) {
+	for i, j := range c.indexes(v) {
+		c.counters[i][j] += n
+	}
+}
+
+// estimate returns how many times a value was counted, or more
+func (c *countMin) estimate(v string) uint64 {
+	var est uint64 = math.MaxUint64
+	for i, j := range c.indexes(v) {
+		if c.counters[i][j] < est {
+			est = c.counters[i][j]
+		}
+	}
+	return est
+}
+
+// merge adds the counters of o
+func (c *countMin) merge(o *countMin) {
+	for i := range c.counters {
+		for j := range c.counters[i] {
+			c.counters[i][j] += o.counters[i][j]
+		}
+	}
+}
+
+// MarshalText encodes the counters as base64 of their varints, which
+// takes a byte for most as they're mostly small
+func (c *countMin) MarshalText() ([]byte, error) {
+	b := make([]byte, 0, countMinDepth*countMinWidth)
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	for i := range c.counters {
+		for _, n := range c.counters[i] {
+			l := binary.PutUvarint(buf, n)
+			b = append(b, buf[:l]...)
+		}
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// UnmarshalText decodes counters encoded by MarshalText
+func (c *countMin) UnmarshalText(text []byte) error {
+	b, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+
+	for i := range c.counters {
+		for j := range c.counters[i] {
+			n, l := binary.Uvarint(b)
+			if l <= 0 {
+				return errors.New("invalid count-min sketch")
+			}
+			c.counters[i][j] = n
+			b = b[l:]
+		}
+	}
+
+	return nil
+}
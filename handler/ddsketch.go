@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// sketchAccuracy is the relative error of the quantiles estimated by a ddsketch
+	sketchAccuracy = 0.01
+	// maxSketchBins is the number of bins a ddsketch of positive or
+	// negative values is limited to, covering 1e-9 to 1e9 at 1% accuracy
+	maxSketchBins = 2048
+	// minSketchValue is the smallest absolute value which isn't counted as zero
+	minSketchValue = 1e-9
+)
+
+var (
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// ddsketch estimates the quantiles of values with a bounded relative error
+// by counting them in logarithmically sized bins, as described in
+// https://arxiv.org/abs/1908.10693. Sketches are merged by adding up bins.
+type ddsketch struct {
+	Positive map[int]uint64 `json:"positive,omitempty"`
+	Negative map[int]uint64 `json:"negative,omitempty"`
+	Zero     uint64         `json:"zero,omitempty"`
+	Count    uint64         `json:"count"`
+}
+
+func newDDSketch() *ddsketch {
+	return &ddsketch{
+		Positive: map[int]uint64{},
+		Negative: map[int]uint64{},
+	}
+}
+
+// sketchIndex returns the bin of an absolute value
+func sketchIndex(v float64) int {
+	return int(math.Ceil(math.Log(v) / sketchLogGamma))
+}
+
+// sketchValue returns the value the absolute values in a bin are estimated as
+func sketchValue(i int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1)
+}
+
+// add counts a value n times
+func (d *ddsketch) add(v float64, n uint64) {
+	switch {
+	case v > minSketchValue:
+		d.Positive[sketchIndex(v)] += n
+		collapse(d.Positive)
+	case v < -minSketchValue:
+		d.Negative[sketchIndex(-v)] += n
+		collapse(d.Negative)
+	default:
+		d.Zero += n
+	}
+	d.Count += n
+}
+
+// merge adds the values counted by o
+func (d *ddsketch) merge(o *ddsketch) {
+	if d.Positive == nil {
+		d.Positive = map[int]uint64{}
+	}
+	if d.Negative == nil {
+		d.Negative = map[int]uint64{}
+	}
+	for i, n := range o.Positive {
+		d.Positive[i] += n
+	}
+	for i, n := range o.Negative {
+		d.Negative[i] += n
+	}
+	collapse(d.Positive)
+	collapse(d.Negative)
+	d.Zero += o.Zero
+	d.Count += o.Count
+}
+
+// collapse folds the bins of the smallest absolute values together until
+// there are at most maxSketchBins, trading the accuracy of those for space
+func collapse(bins map[int]uint64) {
+	if len(bins) <= maxSketchBins {
+		return
+	}
+
+	keys := sortedBins(bins)
+	last := keys[len(keys)-maxSketchBins]
+	for _, i := range keys[:len(keys)-maxSketchBins] {
+		bins[last] += bins[i]
+		delete(bins, i)
+	}
+}
+
+// sortedBins returns the indexes of bins in increasing order
+func sortedBins(bins map[int]uint64) []int {
+	keys := make([]int, 0, len(bins))
+	for i := range bins {
+		keys = append(keys, i)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// quantile returns the estimate of the q quantile of the values, with q
+// between 0 and 1
+func (d *ddsketch) quantile(q float64) float64 {
+	if d.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(d.Count-1))
+	var seen uint64
+
+	// the negative values from the smallest, which have the largest bins
+	neg := sortedBins(d.Negative)
+	for j := len(neg) - 1; j >= 0; j-- {
+		seen += d.Negative[neg[j]]
+		if seen > rank {
+			return -sketchValue(neg[j])
+		}
+	}
+
+	seen += d.Zero
+	if seen > rank {
+		return 0
+	}
+
+	pos := sortedBins(d.Positive)
+	for _, i := range pos {
+		seen += d.Positive[i]
+		if seen > rank {
+			return sketchValue(i)
+		}
+	}
+
+	return sketchValue(pos[len(pos)-1])
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+	pauth "github.com/micro/services/pkg/auth"
+
+	pb "analytics/proto"
+)
+
+// partitionFormat is the layout of the hour partitioning the event log
+const partitionFormat = "2006010215"
+
+// logEntry is an event as it was tracked
+type logEntry struct {
+	*pb.TrackItem
+	// Late is set if the event was counted outside of the time buckets
+	Late bool `json:"late,omitempty"`
+	// Measurement is how the amount was counted, if not by Track
+	Measurement *measurement `json:"measurement,omitempty"`
+}
+
+// logPart is the events a batch logged in a partition. It's written once
+// and never changed, so the log is append only.
+type logPart struct {
+	Events []*logEntry `json:"events"`
+}
+
+// apply writes the logged events at key
+func (l *logPart) apply(key string) error {
+	rec := &store.Record{Key: key}
+	if err := rec.Encode(l); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// logPrefix returns the store prefix of the event log of a tenant
+func logPrefix(tnt string) string {
+	return keyPrefix(kindLog, tnt)
+}
+
+// logKey returns the store prefix of the events a batch logged in the partition of t
+func logKey(tnt string, t time.Time, batch string) string {
+	return keyPrefix(kindLog, tnt, t.Format(partitionFormat), batch)
+}
+
+// log appends item i, which happened at t and was measured by m, to the event log
+func (b *batch) log(i int, tnt string, item *pb.TrackItem, t time.Time, late bool, m *measurement) {
+	l := b.pending(i, logKey(tnt, t, b.id), func() change {
+		return &logPart{}
+	}).(*logPart)
+
+	l.Events = append(l.Events, &logEntry{
+		TrackItem: &pb.TrackItem{
+			Name:       item.Name,
+			Amount:     item.Amount,
+			Properties: item.Properties,
+			Timestamp:  t.Format(time.RFC3339),
+			DistinctId: item.DistinctId,
+			UserAgent:  item.UserAgent,
+		},
+		Late:        late,
+		Measurement: m,
+	})
+}
+
+// logComplete returns whether the sorted keys of the event log of a tenant
+// start no later than the hour of the first event counted
+func logComplete(tnt string, keys []string) (bool, error) {
+	events, err := readRecords(keyPrefix(kindEvent, tnt))
+	if err != nil {
+		return false, err
+	}
+
+	var first string
+	for _, e := range events {
+		if len(e.Created) > 0 && (len(first) == 0 || e.Created < first) {
+			first = e.Created
+		}
+	}
+	if len(first) == 0 {
+		return true, nil
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+
+	created, err := time.Parse(time.RFC3339, first)
+	if err != nil {
+		return false, err
+	}
+
+	segments, ok := keySegments(kindLog, keys[0])
+	if !ok || len(segments) < 2 {
+		return false, nil
+	}
+
+	return segments[1] <= created.UTC().Format(partitionFormat), nil
+}
+
+// Replay rebuilds the aggregates of a tenant from its event log. It's
+// refused if the log is disabled, or doesn't go back as far as the events
+// counted unless forced. Only this replica stops counting while the
+// aggregates are rebuilt, so events other replicas track between them being
+// deleted and the log being listed are counted twice: stop tracking on the
+// other replicas before replaying.
+func (a *Analytics) Replay(ctx context.Context, req *pb.ReplayRequest, rsp *pb.ReplayResponse) error {
+	method := "analytics.replay"
+	if _, err := pauth.VerifyMicroAdmin(ctx, method); err != nil {
+		return err
+	}
+
+	if len(req.TenantId) == 0 {
+		return errors.BadRequest(method, "missing tenant id")
+	}
+
+	// without the log every aggregate would be lost
+	if !a.eventLog {
+		return errors.BadRequest(method, "event log is disabled")
+	}
+
+	// keep this replica from counting while the aggregates are rebuilt
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	keys, err := store.List(store.ListPrefix(logPrefix(req.TenantId)))
+	if err != nil {
+		return errors.InternalServerError(method, "Error reading from store: %v", err.Error())
+	}
+	sort.Strings(keys)
+
+	// the events counted before the log was enabled would be lost
+	if !req.Force {
+		complete, err := logComplete(req.TenantId, keys)
+		if err != nil {
+			return errors.InternalServerError(method, "Error reading from store: %v", err.Error())
+		}
+		if !complete {
+			return errors.BadRequest(method, "event log starts after the first event counted, force the replay to lose the earlier events")
+		}
+	}
+
+	// remove every aggregate
+	for _, kind := range []string{kindEvent, kindSeries, kindDimension, kindEnrichment, kindHistory, kindActivity, kindSession, kindSessionStats, kindTopValues} {
+		if err := deleteEvents(keyPrefix(kind, req.TenantId)); err != nil {
+			return errors.InternalServerError(method, "Error deleting from store: %v", err.Error())
+		}
+	}
+
+	// count the logged events again a batch at a time
+	b := a.newBatch()
+	var n int
+
+	commit := func() error {
+		for _, err := range b.commit() {
+			return errors.InternalServerError(method, "Error writing to store: %v", err.Error())
+		}
+		b = a.newBatch()
+		n = 0
+		return nil
+	}
+
+	for _, key := range keys {
+		recs, err := store.Read(key)
+		if err == store.ErrNotFound {
+			continue
+		} else if err != nil {
+			return errors.InternalServerError(method, "Error reading from store: %v", err.Error())
+		}
+
+		var part logPart
+		if err := recs[0].Decode(&part); err != nil {
+			logger.Warnf("Skipping replay of unknown record %s", key)
+			continue
+		}
+
+		for _, e := range part.Events {
+			t, err := time.Parse(time.RFC3339, e.Timestamp)
+			if err != nil || e.TrackItem == nil {
+				logger.Warnf("Skipping replay of invalid event in %s", key)
+				continue
+			}
+
+			b.track(n, req.TenantId, e.TrackItem, t.UTC(), e.Late, e.Measurement)
+			n++
+			rsp.Events++
+		}
+
+		if n >= maxBatch {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := commit(); err != nil {
+		return err
+	}
+
+	logger.Infof("Replayed %d events of %s", rsp.Events, req.TenantId)
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// maxSteps is the maximum number of steps in a funnel
+const maxSteps = 10
+
+// parseWindow parses a duration such as 30m or 24h, also accepting a
+// number of days such as 7d
+func parseWindow(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// reached returns the number of steps a user went through in order, each
+// within window of the first. Every time the user entered the funnel
+// between start and end is tried, keeping the furthest one.
+func reached(events []*occurrence, steps []string, window time.Duration, start, end time.Time) int {
+	var best int
+
+	for i, o := range events {
+		if o.Name != steps[0] || o.Time < start.Unix() || o.Time > end.Unix() {
+			continue
+		}
+
+		deadline := o.Time + int64(window/time.Second)
+		n := 1
+		for _, next := range events[i+1:] {
+			if n == len(steps) || next.Time > deadline {
+				break
+			}
+			if next.Name == steps[n] {
+				n++
+			}
+		}
+
+		if n > best {
+			best = n
+		}
+		if best == len(steps) {
+			break
+		}
+	}
+
+	return best
+}
+
+// Funnel returns how many users did a sequence of events in order
+func (a *Analytics) Funnel(ctx context.Context, req *pb.FunnelRequest, rsp *pb.FunnelResponse) error {
+	// Validate the request
+	if len(req.Steps) < 2 {
+		return errors.BadRequest("analytics.funnel", "at least 2 steps are required")
+	}
+	if len(req.Steps) > maxSteps {
+		return errors.BadRequest("analytics.funnel", "too many steps, at most %d are allowed", maxSteps)
+	}
+	for _, s := range req.Steps {
+		if len(s) == 0 {
+			return errors.BadRequest("analytics.funnel", "missing step name")
+		}
+	}
+
+	window := 24 * time.Hour
+	if len(req.Window) > 0 {
+		w, err := parseWindow(req.Window)
+		if err != nil || w <= 0 {
+			return errors.BadRequest("analytics.funnel", "invalid window")
+		}
+		window = w
+	}
+
+	start, end, err := parseRange("analytics.funnel", req.Start, req.End, 7*24*time.Hour, nil)
+	if err != nil {
+		return err
+	}
+	if end.Add(window).Sub(start) > historyExpiry {
+		return errors.BadRequest("analytics.funnel", "range and window exceed %d days", historyExpiry/(24*time.Hour))
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	// Read the events of every user who may have completed the funnel
+	histories, err := readHistories(tnt, start, end.Add(window))
+	if err == errTooManyHistories {
+		return errors.BadRequest("analytics.funnel", "more than %d users triggered events in the range, narrow it", maxHistoryUsers)
+	} else if err != nil {
+		return errors.InternalServerError("analytics.funnel", "Error reading from store: %v", err.Error())
+	}
+
+	users := make([]uint64, len(req.Steps))
+	for _, events := range histories {
+		n := reached(events, req.Steps, window, start, end)
+		for i := 0; i < n; i++ {
+			users[i]++
+		}
+	}
+
+	rsp.Steps = make([]*pb.FunnelStep, len(req.Steps))
+	for i, name := range req.Steps {
+		step := &pb.FunnelStep{Name: name, Users: users[i]}
+		if users[0] > 0 {
+			step.Conversion = float64(users[i]) / float64(users[0])
+		}
+		if i == 0 {
+			step.StepConversion = step.Conversion
+		} else if users[i-1] > 0 {
+			step.StepConversion = float64(users[i]) / float64(users[i-1])
+		}
+		rsp.Steps[i] = step
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/micro/micro/v3/service/logger"
+
+	pb "analytics/proto"
+)
+
+// GeoIP locates ip addresses offline with a MaxMind DB file e.g
+// GeoLite2-City.mmdb, reloading it when the file changes
+type GeoIP struct {
+	path string
+	// truncate is set to drop the host part of addresses, keeping the /24
+	// of IPv4 and the /48 of IPv6, before they're looked up
+	truncate bool
+
+	lock    sync.RWMutex
+	db      *mmdb
+	modTime time.Time
+}
+
+// OpenGeoIP loads the MaxMind DB file at path
+func OpenGeoIP(path string, truncate bool) (*GeoIP, error) {
+	g := &GeoIP{path: path, truncate: truncate}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// load reads the file unless it hasn't changed since it was last read
+func (g *GeoIP) load() error {
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+
+	g.lock.RLock()
+	unchanged := info.ModTime().Equal(g.modTime)
+	g.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	db, err := openMMDB(g.path)
+	if err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	g.db, g.modTime = db, info.ModTime()
+	g.lock.Unlock()
+
+	return nil
+}
+
+// Watch reloads the file every interval it's changed, keeping the
+// previous version if the new one is invalid e.g while it's being copied
+func (g *GeoIP) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := g.load(); err != nil {
+			logger.Errorf("Error reloading GeoIP database %s: %v", g.path, err)
+		}
+	}
+}
+
+// truncateIP returns the network of an ip, its /24 for IPv4 and /48 for IPv6
+func truncateIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(48, 128))
+}
+
+// lookupName returns the English name at a path of maps in a record
+func lookupName(v interface{}, path ...string) string {
+	for _, k := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[k]
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// lookupValue returns the value of a key of a record
+func lookupValue(v interface{}, key string) interface{} {
+	m, _ := v.(map[string]interface{})
+	return m[key]
+}
+
+// locate returns the country, region and city properties of an ip address
+func (g *GeoIP) locate(addr string) map[string]string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	if g.truncate {
+		ip = truncateIP(ip)
+	}
+
+	g.lock.RLock()
+	db := g.db
+	g.lock.RUnlock()
+
+	v, err := db.lookup(ip)
+	if err != nil {
+		logger.Errorf("Error looking up %s in GeoIP database: %v", g.path, err)
+		return nil
+	}
+	if v == nil {
+		return nil
+	}
+
+	props := map[string]string{}
+	if s := lookupName(v, "country", "iso_code"); len(s) > 0 {
+		props["country"] = s
+	}
+	if subs, ok := lookupValue(v, "subdivisions").([]interface{}); ok && len(subs) > 0 {
+		if s := lookupName(subs[0], "names", "en"); len(s) > 0 {
+			props["region"] = s
+		}
+	}
+	if s := lookupName(v, "city", "names", "en"); len(s) > 0 {
+		props["city"] = s
+	}
+
+	return props
+}
+
+// locate records where the item came from as properties, which the item
+// defines take precedence over, and discards its ip address
+func (a *Analytics) locate(item *pb.TrackItem) {
+	if len(item.Ip) == 0 {
+		return
+	}
+	addr := item.Ip
+	item.Ip = ""
+
+	if a.geoIP == nil {
+		return
+	}
+
+	geo := a.geoIP.locate(addr)
+	if len(geo) == 0 {
+		return
+	}
+
+	for k, v := range item.Properties {
+		geo[k] = v
+	}
+	item.Properties = geo
+}
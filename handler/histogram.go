@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"sort"
+
+	pb "analytics/proto"
+)
+
+// histogram counts values in buckets with fixed upper bounds, the last
+// bucket holding the values above every bound
+type histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// add counts a value n times in the first bucket whose bound is at least the value
+func (h *histogram) add(v float64, n uint64) {
+	h.Counts[sort.SearchFloat64s(h.Bounds, v)] += n
+}
+
+// sameBounds returns whether two histograms have the same buckets
+func (h *histogram) sameBounds(o *histogram) bool {
+	if len(h.Bounds) != len(o.Bounds) || len(h.Counts) != len(o.Counts) {
+		return false
+	}
+	for i, b := range h.Bounds {
+		if b != o.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// merge adds the counts of o, unless its buckets are different e.g as the
+// histogram was declared again
+func (h *histogram) merge(o *histogram) {
+	if !h.sameBounds(o) {
+		return
+	}
+	for i, n := range o.Counts {
+		h.Counts[i] += n
+	}
+}
+
+// bucketBounds returns the lower and upper bounds of bucket i, given the
+// smallest and largest values counted
+func (h *histogram) bucketBounds(i int, min, max float64) (float64, float64) {
+	lower, upper := min, max
+	if i > 0 && h.Bounds[i-1] > lower {
+		lower = h.Bounds[i-1]
+	}
+	if i < len(h.Bounds) && h.Bounds[i] < upper {
+		upper = h.Bounds[i]
+	}
+	return lower, upper
+}
+
+// buckets returns the buckets holding values, given the smallest and
+// largest values counted
+func (h *histogram) buckets(min, max float64) []*pb.Bucket {
+	var buckets []*pb.Bucket
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		lower, upper := h.bucketBounds(i, min, max)
+		buckets = append(buckets, &pb.Bucket{Lower: lower, Upper: upper, Count: n})
+	}
+	return buckets
+}
+
+// quantile returns the estimate of the q quantile of the values, with q
+// between 0 and 1, assuming they're spread evenly within every bucket
+func (h *histogram) quantile(q, min, max float64) float64 {
+	var total uint64
+	for _, n := range h.Counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var seen float64
+
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		if seen+float64(n) >= rank {
+			lower, upper := h.bucketBounds(i, min, max)
+			return lower + (upper-lower)*(rank-seen)/float64(n)
+		}
+		seen += float64(n)
+	}
+
+	return max
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/micro/micro/v3/service/store"
+)
+
+// maxHistory is the number of events kept per user and day, later ones are dropped
+const maxHistory = 1000
+
+// historyExpiry is how long the history of a user is kept
+const historyExpiry = 90 * 24 * time.Hour
+
+const (
+	// maxHistoryUsers is the maximum number of users whose histories are
+	// read at once
+	maxHistoryUsers = 100000
+	// maxHistoryRecords is the maximum number of records, a shard of the
+	// history of a user during a day, read at once
+	maxHistoryRecords = 500000
+)
+
+// errTooManyHistories is returned when reading more histories than the limits
+var errTooManyHistories = errors.New("too many histories")
+
+// occurrence is an event triggered by a user
+type occurrence struct {
+	Name string `json:"name"`
+	// unix time in seconds
+	Time int64 `json:"time"`
+}
+
+// history is the events a user triggered during a day
+type history struct {
+	DistinctId string        `json:"distinct_id"`
+	Events     []*occurrence `json:"events"`
+}
+
+// historyPrefix returns the store prefix of the histories of every user during a day
+func historyPrefix(tnt string, day time.Time) string {
+	return keyPrefix(kindHistory, tnt, day.Format(dayFormat))
+}
+
+// historyKey returns the store prefix of the history of a user during a day
+func historyKey(tnt string, day time.Time, id string) string {
+	return keyPrefix(kindHistory, tnt, day.Format(dayFormat), id)
+}
+
+// record adds an occurrence to the history of a user
+func (h *history) record(o ...*occurrence) {
+	h.Events = append(h.Events, o...)
+	sort.SliceStable(h.Events, func(i, j int) bool {
+		return h.Events[i].Time < h.Events[j].Time
+	})
+	if len(h.Events) > maxHistory {
+		h.Events = h.Events[:maxHistory]
+	}
+}
+
+// appendHistory is a pending change to the history stored at a key
+type appendHistory struct {
+	id     string
+	events []*occurrence
+}
+
+// apply adds the events to the history stored at key
+func (h *appendHistory) apply(key string) error {
+	hist := &history{DistinctId: h.id}
+
+	recs, err := store.Read(key)
+	if err == nil {
+		if err := recs[0].Decode(hist); err != nil {
+			return err
+		}
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	hist.record(h.events...)
+
+	rec := &store.Record{Key: key, Expiry: historyExpiry}
+	if err := rec.Encode(hist); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// readHistories returns the history of every user between start and end,
+// keyed by distinct id, with the events of each in chronological order.
+// It returns errTooManyHistories if there are more than maxHistoryRecords
+// records or maxHistoryUsers users, before reading them all.
+func readHistories(tnt string, start, end time.Time) (map[string][]*occurrence, error) {
+	var days []time.Time
+	var records int
+
+	// count the records before reading any
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+		keys, err := store.List(store.ListPrefix(historyPrefix(tnt, day)))
+		if err != nil {
+			return nil, err
+		}
+		if records += len(keys); records > maxHistoryRecords {
+			return nil, errTooManyHistories
+		}
+		days = append(days, day)
+	}
+
+	histories := map[string][]*occurrence{}
+
+	for _, day := range days {
+		recs, err := store.Read(historyPrefix(tnt, day), store.ReadPrefix())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range recs {
+			var hist history
+			if err := rec.Decode(&hist); err != nil {
+				return nil, err
+			}
+			for _, o := range hist.Events {
+				if o.Time < start.Unix() || o.Time > end.Unix() {
+					continue
+				}
+				histories[hist.DistinctId] = append(histories[hist.DistinctId], o)
+			}
+		}
+
+		if len(histories) > maxHistoryUsers {
+			return nil, errTooManyHistories
+		}
+	}
+
+	// the shards of a user's history are read one after the other
+	for _, events := range histories {
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Time < events[j].Time
+		})
+	}
+
+	return histories, nil
+}
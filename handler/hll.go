@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of hash bits picking a register. 4096
+// registers estimate uniques with a standard error of about 1.6%.
+const hllPrecision = 12
+
+const hllRegisters = 1 << hllPrecision
+
+// encodings of a serialized sketch
+const (
+	hllSparse byte = iota
+	hllDense
+)
+
+// hll is a HyperLogLog sketch estimating the number of distinct ids.
+// Sketches are merged by keeping the largest value of every register,
+// so the uniques of a range are estimated by merging its buckets.
+type hll struct {
+	registers [hllRegisters]uint8
+}
+
+func newHLL() *hll {
+	return &hll{}
+}
+
+// hash returns a well distributed 64 bit hash of an id
+func hash(id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	x := h.Sum64()
+
+	// finalise with murmur3's mix as fnv leaves the high bits biased
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// add counts an id
+func (h *hll) add(id string) {
+	x := hash(id)
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// merge adds the ids counted by o
+func (h *hll) merge(o *hll) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// count returns the estimated number of distinct ids
+func (h *hll) count() uint64 {
+	var sum float64
+	var zeros int
+
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// use linear counting for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// MarshalText encodes the sketch as base64. Sketches with few ids only
+// keep their non-zero registers.
+func (h *hll) MarshalText() ([]byte, error) {
+	var sparse []byte
+	for i, r := range h.registers {
+		if r == 0 {
+			continue
+		}
+		sparse = append(sparse, byte(i>>8), byte(i), r)
+	}
+
+	b := append([]byte{hllSparse}, sparse...)
+	if len(sparse) > hllRegisters {
+		b = append([]byte{hllDense}, h.registers[:]...)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// UnmarshalText decodes a sketch encoded by MarshalText
+func (h *hll) UnmarshalText(text []byte) error {
+	b, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return errors.New("empty sketch")
+	}
+
+	*h = hll{}
+
+	switch b[0] {
+	case hllSparse:
+		if len(b[1:])%3 != 0 {
+			return errors.New("invalid sparse sketch")
+		}
+		for j := 1; j < len(b); j += 3 {
+			i := int(b[j])<<8 | int(b[j+1])
+			if i >= hllRegisters {
+				return errors.New("invalid sparse sketch")
+			}
+			h.registers[i] = b[j+2]
+		}
+	case hllDense:
+		if len(b[1:]) != hllRegisters {
+			return errors.New("invalid dense sketch")
+		}
+		copy(h.registers[:], b[1:])
+	default:
+		return errors.New("unknown sketch encoding")
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// idSet is the exact set of the hashes of distinct ids. Unlike a sketch
+// sets can be intersected, which cohorts need to follow their users.
+type idSet struct {
+	ids map[uint64]struct{}
+}
+
+func newIDSet() *idSet {
+	return &idSet{ids: map[uint64]struct{}{}}
+}
+
+// add adds the hash of an id
+func (s *idSet) add(x uint64) {
+	s.ids[x] = struct{}{}
+}
+
+// has returns whether the set contains the hash of an id
+func (s *idSet) has(x uint64) bool {
+	_, ok := s.ids[x]
+	return ok
+}
+
+// merge adds the ids of o
+func (s *idSet) merge(o *idSet) {
+	for x := range o.ids {
+		s.ids[x] = struct{}{}
+	}
+}
+
+// count returns the number of ids in the set
+func (s *idSet) count() uint64 {
+	return uint64(len(s.ids))
+}
+
+// MarshalText encodes the set as base64 of the varint differences of its
+// sorted hashes, which takes less than the hashes themselves
+func (s *idSet) MarshalText() ([]byte, error) {
+	sorted := make([]uint64, 0, len(s.ids))
+	for x := range s.ids {
+		sorted = append(sorted, x)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	b := make([]byte, 0, len(sorted)*binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	var last uint64
+	for _, x := range sorted {
+		n := binary.PutUvarint(buf, x-last)
+		b = append(b, buf[:n]...)
+		last = x
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// UnmarshalText decodes a set encoded by MarshalText
+func (s *idSet) UnmarshalText(text []byte) error {
+	b, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = *newIDSet()
+
+	var last uint64
+	for len(b) > 0 {
+		d, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid id set")
+		}
+		last += d
+		s.ids[last] = struct{}{}
+		b = b[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"strings"
+)
+
+// keyVersion namespaces every key so the layout can change in future
+const keyVersion = "v1"
+
+// separator delimits the segments of a key
+const separator = "/"
+
+// kinds of records kept in the store
+const (
+	kindEvent        = "event"
+	kindSeries       = "series"
+	kindDimension    = "dims"
+	kindEnrichment   = "enriched"
+	kindHistory      = "history"
+	kindActivity     = "active"
+	kindLog          = "log"
+	kindSite         = "site"
+	kindSiteKey      = "sitekey"
+	kindFiltered     = "filtered"
+	kindSession      = "session"
+	kindSessionStats = "sessions"
+	kindType         = "type"
+	kindTopValues    = "topvalues"
+	kindMigration    = "migration"
+	kindReplica      = "replica"
+)
+
+// dayFormat is the layout of the days in keys
+const dayFormat = "20060102"
+
+var escaper = strings.NewReplacer("%", "%25", separator, "%2F")
+
+// escape encodes a segment so it never contains the separator
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+var unescaper = strings.NewReplacer("%2F", separator, "%25", "%")
+
+// unescape decodes a segment encoded by escape
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
+
+// keyPrefix returns the store prefix of the records of a kind, e.g
+// v1/event/<tenant>/<name>/. The first segment is always the tenant,
+// except for site keys which resolve it.
+// Every segment is escaped and the prefix ends with the separator so
+// reading it never matches the records of a longer tenant or name.
+func keyPrefix(kind string, segments ...string) string {
+	var b strings.Builder
+
+	b.WriteString(keyVersion + separator + kind + separator)
+	for _, s := range segments {
+		b.WriteString(escape(s))
+		b.WriteString(separator)
+	}
+
+	return b.String()
+}
+
+// shardKey returns the key of a replica's shard of the record at prefix
+func shardKey(prefix, shard string) string {
+	return prefix + escape(shard)
+}
+
+// shardPrefix returns the prefix of the record a shard's key is part of
+func shardPrefix(key string) string {
+	return key[:strings.LastIndex(key, separator)+1]
+}
+
+// keySegments returns the unescaped segments of the key of a record of a
+// kind, the last one being the shard
+func keySegments(kind, key string) ([]string, bool) {
+	base := keyVersion + separator + kind + separator
+	if !strings.HasPrefix(key, base) {
+		return nil, false
+	}
+
+	segments := strings.Split(strings.TrimPrefix(key, base), separator)
+	for i, s := range segments {
+		segments[i] = unescape(s)
+	}
+
+	return segments, true
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+
+	"github.com/micro/micro/v3/service/store"
+
+	pb "analytics/proto"
+)
+
+const (
+	// defaultListLimit is the number of events returned by List if no
+	// limit is requested
+	defaultListLimit = 100
+	// maxListLimit is the maximum number of events returned by List
+	maxListLimit = 1000
+)
+
+// pageCursor is the last event of a page, which the next page starts after
+type pageCursor struct {
+	Name    string `json:"name"`
+	Value   uint64 `json:"value,omitempty"`
+	Created string `json:"created,omitempty"`
+}
+
+// encodePageToken returns an opaque token for the page after an event
+func encodePageToken(last *pb.Event) string {
+	b, _ := json.Marshal(&pageCursor{Name: last.Name, Value: last.Value, Created: last.Created})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodePageToken returns the event the page of a token starts after, or
+// nil for the first page
+func decodePageToken(token string) (*pb.Event, error) {
+	if len(token) == 0 {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+
+	return &pb.Event{Name: c.Name, Value: c.Value, Created: c.Created}, nil
+}
+
+// listOrder returns whether event a is listed before b. Events are
+// ordered by the field sorted by then by name, so the order is total.
+func listOrder(req *pb.ListRequest) func(a, b *pb.Event) bool {
+	less := func(a, b *pb.Event) bool {
+		if req.SortBy == "value" && a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		if req.SortBy == "created" && a.Created != b.Created {
+			return a.Created < b.Created
+		}
+		return a.Name < b.Name
+	}
+
+	if req.Order == "desc" {
+		return func(a, b *pb.Event) bool { return less(b, a) }
+	}
+	return less
+}
+
+// page returns the events listed after the last event of the previous
+// page, up to the limit, and the last event returned if there are more
+func page(events []*pb.Event, req *pb.ListRequest, after *pb.Event) ([]*pb.Event, *pb.Event) {
+	before := listOrder(req)
+
+	if after != nil {
+		i := sort.Search(len(events), func(i int) bool { return before(after, events[i]) })
+		events = events[i:]
+	}
+
+	if len(events) <= int(req.Limit) {
+		return events, nil
+	}
+
+	events = events[:req.Limit]
+	return events, events[len(events)-1]
+}
+
+// listByName returns the page of events sorted by name after an event.
+// Only the keys of the events are listed to find the names in the page.
+// They're sorted in memory as escaping names and the separator
+// following them sort keys differently, e.g click/ after click.x/.
+// It returns the last event of the page if there are more.
+func listByName(tnt string, req *pb.ListRequest, after *pb.Event) ([]*pb.Event, *pb.Event, error) {
+	keys, err := store.List(store.ListPrefix(keyPrefix(kindEvent, tnt) + escape(req.Prefix)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the distinct names, as events ordered by name alone
+	var names []*pb.Event
+	seen := map[string]bool{}
+
+	for _, key := range keys {
+		segments, ok := keySegments(kindEvent, key)
+		if !ok || len(segments) != 3 || seen[segments[1]] {
+			continue
+		}
+		seen[segments[1]] = true
+		names = append(names, &pb.Event{Name: segments[1]})
+	}
+
+	before := listOrder(req)
+	sort.Slice(names, func(i, j int) bool { return before(names[i], names[j]) })
+
+	names, next := page(names, req, after)
+
+	events := make([]*pb.Event, 0, len(names))
+
+	for _, name := range names {
+		event, err := readEvent(tnt, name.Name)
+		if err == store.ErrNotFound {
+			// deleted since it was listed
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, next, nil
+}
+
+// listSorted returns the page of events sorted by value or creation time
+// after an event. It returns the last event of the page if there are more.
+func listSorted(tnt string, req *pb.ListRequest, after *pb.Event) ([]*pb.Event, *pb.Event, error) {
+	shards, err := readRecords(keyPrefix(kindEvent, tnt) + escape(req.Prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var events []*pb.Event
+	for _, total := range sumByName(shards) {
+		events = append(events, total.Event)
+	}
+
+	before := listOrder(req)
+	sort.Slice(events, func(i, j int) bool { return before(events[i], events[j]) })
+
+	events, next := page(events, req, after)
+	if events == nil {
+		events = []*pb.Event{}
+	}
+
+	return events, next, nil
+}
@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+
+	pb "analytics/proto"
+)
+
+// maxLabelLength is the number of bytes label values are truncated to
+const maxLabelLength = 128
+
+// eventMetrics are the metric families rendered for every event
+var eventMetrics = []struct {
+	name  string
+	help  string
+	kind  string
+	value func(*pb.Event) float64
+}{
+	{
+		name:  "analytics_events_total",
+		help:  "Number of times an event was tracked.",
+		kind:  "counter",
+		value: func(e *pb.Event) float64 { return float64(e.Value) },
+	},
+	{
+		name:  "analytics_event_amount_total",
+		help:  "Total of the amounts tracked with an event.",
+		kind:  "counter",
+		value: func(e *pb.Event) float64 { return e.Sum },
+	},
+	{
+		name:  "analytics_event_uniques",
+		help:  "Approximate number of distinct ids which triggered an event.",
+		kind:  "gauge",
+		value: func(e *pb.Event) float64 { return float64(e.Uniques) },
+	},
+	{
+		name:  "analytics_events_late_total",
+		help:  "Number of times an event was tracked with a timestamp out of range.",
+		kind:  "counter",
+		value: func(e *pb.Event) float64 { return float64(e.Late) },
+	},
+}
+
+var (
+	invalidLabel = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Metrics renders the events of every tenant in the Prometheus text format
+type Metrics struct {
+	// maxEvents is the number of events exposed per tenant, the most
+	// frequent ones are kept
+	maxEvents int
+	// maxProperties is the number of property combinations exposed per event
+	maxProperties int
+	// maxRecords is the number of records of each kind read per tenant
+	maxRecords int
+	// cacheTTL is how long the series read are exposed before being read again
+	cacheTTL time.Duration
+
+	// lock guards the series read by the last scrape
+	lock      sync.Mutex
+	cached    []*tenantMetrics
+	collected time.Time
+}
+
+// NewMetrics returns Metrics limited by the cardinality set in the config
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		maxEvents:     1000,
+		maxProperties: 100,
+		maxRecords:    10000,
+		cacheTTL:      time.Minute,
+	}
+
+	if v, err := config.Get("analytics.metrics_max_events"); err == nil {
+		m.maxEvents = v.Int(m.maxEvents)
+	}
+	if v, err := config.Get("analytics.metrics_max_properties"); err == nil {
+		m.maxProperties = v.Int(m.maxProperties)
+	}
+	if v, err := config.Get("analytics.metrics_max_records"); err == nil {
+		m.maxRecords = v.Int(m.maxRecords)
+	}
+	if v, err := config.Get("analytics.metrics_cache_ttl"); err == nil {
+		m.cacheTTL = v.Duration(m.cacheTTL)
+	}
+
+	return m
+}
+
+// tenantMetrics are the series exposed for a tenant
+type tenantMetrics struct {
+	tnt    string
+	events []*pb.Event
+	// properties holds the property combinations of every event
+	properties map[string][]*pb.Event
+	// dropped counts the events and property combinations left out by the
+	// cardinality limits, and the records left unread
+	dropped int
+	// filtered counts the events of bots by event and action
+	filtered []*pb.Event
+}
+
+// readTotals returns the records of a kind summed across shards, keyed by
+// tenant and then by every other segment of their key. At most limit
+// records are read per tenant, ending with the last record whose shards
+// were all read, and the number left out is returned by tenant.
+func readTotals(kind string, limit int) (map[string]map[string]*record, map[string]int, error) {
+	keys, err := store.List(store.ListPrefix(keyVersion + separator + kind + separator))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// count the records of every tenant to read them a tenant at a time
+	counts := map[string]int{}
+	for _, key := range keys {
+		segments, ok := keySegments(kind, key)
+		if !ok || len(segments) < 2 {
+			continue
+		}
+		counts[segments[0]]++
+	}
+
+	totals := map[string]map[string]*record{}
+	truncated := map[string]int{}
+
+	for tnt, n := range counts {
+		opts := []store.ReadOption{store.ReadPrefix()}
+		if limit > 0 && n > limit {
+			opts = append(opts, store.ReadLimit(uint(limit)))
+		}
+
+		recs, err := store.Read(keyPrefix(kind, tnt), opts...)
+		if err != nil && err != store.ErrNotFound {
+			return nil, nil, err
+		}
+
+		// the shards of a record have adjacent keys, so the last record
+		// read may be missing shards which would show as a counter reset
+		if limit > 0 && n > limit && len(recs) > 0 {
+			last := shardPrefix(recs[len(recs)-1].Key)
+			for len(recs) > 0 && shardPrefix(recs[len(recs)-1].Key) == last {
+				recs = recs[:len(recs)-1]
+			}
+			truncated[tnt] = n - len(recs)
+		}
+
+		for _, rec := range recs {
+			segments, ok := keySegments(kind, rec.Key)
+			if !ok || len(segments) < 2 {
+				continue
+			}
+
+			r, err := decodeRecord(rec)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if totals[tnt] == nil {
+				totals[tnt] = map[string]*record{}
+			}
+			addShard(totals[tnt], strings.Join(segments[1:len(segments)-1], separator), r)
+		}
+	}
+
+	return totals, truncated, nil
+}
+
+// mostFrequent sorts events by value, most frequent first, and returns
+// at most limit of them along with the number left out
+func mostFrequent(events []*pb.Event, limit int) ([]*pb.Event, int) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Value != events[j].Value {
+			return events[i].Value > events[j].Value
+		}
+		return events[i].Name < events[j].Name
+	})
+
+	if limit <= 0 || len(events) <= limit {
+		return events, 0
+	}
+
+	return events[:limit], len(events) - limit
+}
+
+// cachedCollect returns the series read by the last scrape, unless they
+// are older than the cache ttl
+func (m *Metrics) cachedCollect() ([]*tenantMetrics, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.cached != nil && time.Since(m.collected) < m.cacheTTL {
+		return m.cached, nil
+	}
+
+	tenants, err := m.collect()
+	if err != nil {
+		return nil, err
+	}
+	m.cached, m.collected = tenants, time.Now()
+
+	return tenants, nil
+}
+
+// collect reads the series to expose for every tenant
+func (m *Metrics) collect() ([]*tenantMetrics, error) {
+	events, truncated, err := readTotals(kindEvent, m.maxRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	dims, truncatedDims, err := readTotals(kindDimension, m.maxRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenants []*tenantMetrics
+
+	for tnt, totals := range events {
+		t := &tenantMetrics{
+			tnt:        tnt,
+			properties: map[string][]*pb.Event{},
+			dropped:    truncated[tnt] + truncatedDims[tnt],
+		}
+
+		for _, total := range totals {
+			t.events = append(t.events, total.Event)
+		}
+		var dropped int
+		t.events, dropped = mostFrequent(t.events, m.maxEvents)
+		t.dropped += dropped
+
+		// only expose the properties of the exposed events
+		exposed := map[string]bool{}
+		for _, e := range t.events {
+			exposed[e.Name] = true
+		}
+		for _, total := range dims[tnt] {
+			if exposed[total.Name] && len(total.Properties) > 0 {
+				t.properties[total.Name] = append(t.properties[total.Name], total.Event)
+			}
+		}
+		for name, props := range t.properties {
+			t.properties[name], dropped = mostFrequent(props, m.maxProperties)
+			t.dropped += dropped
+		}
+
+		tenants = append(tenants, t)
+	}
+
+	filtered, truncatedFiltered, err := readTotals(kindFiltered, m.maxRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	// tenants only bots tracked events for are exposed too
+	byTenant := map[string]*tenantMetrics{}
+	for _, t := range tenants {
+		byTenant[t.tnt] = t
+	}
+	for tnt, totals := range filtered {
+		t, ok := byTenant[tnt]
+		if !ok {
+			t = &tenantMetrics{tnt: tnt}
+			tenants = append(tenants, t)
+		}
+		t.dropped += truncatedFiltered[tnt]
+		for _, total := range totals {
+			t.filtered = append(t.filtered, total.Event)
+		}
+		var dropped int
+		t.filtered, dropped = mostFrequent(t.filtered, m.maxEvents)
+		t.dropped += dropped
+	}
+
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].tnt < tenants[j].tnt })
+
+	return tenants, nil
+}
+
+// labelName returns a valid label name for a property key which can't be
+// mistaken for the tenant or event labels
+func labelName(key string) string {
+	name := invalidLabel.ReplaceAllString(key, "_")
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') || strings.HasPrefix(name, "__") ||
+		name == "tenant" || name == "event" {
+		name = "property_" + name
+	}
+	return name
+}
+
+// labelValue returns a label value quoted and truncated to maxLabelLength
+func labelValue(v string) string {
+	if len(v) > maxLabelLength {
+		v = v[:maxLabelLength]
+		for !utf8.ValidString(v) {
+			v = v[:len(v)-1]
+		}
+	}
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// seriesLabels renders the labels of a series
+func seriesLabels(tnt, event string, props map[string]string) string {
+	var b strings.Builder
+
+	b.WriteString("{tenant=" + labelValue(tnt))
+	if len(event) > 0 {
+		b.WriteString(",event=" + labelValue(event))
+	}
+
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// keys which only differ by invalid characters get the same name
+	seen := map[string]bool{}
+	for _, k := range keys {
+		name := labelName(k)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		b.WriteString("," + name + "=" + labelValue(props[k]))
+	}
+
+	b.WriteString("}")
+
+	return b.String()
+}
+
+// writeSample renders a sample of a metric family
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name + labels + " " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// writeHeader renders the help and type of a metric family
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// ServeHTTP renders the metrics
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	tenants, err := m.cachedCollect()
+	if err != nil {
+		logger.Errorf("Error reading metrics from store: %v", err)
+		http.Error(rw, "Error reading from store", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	w := bufio.NewWriter(rw)
+	defer w.Flush()
+
+	for _, metric := range eventMetrics {
+		writeHeader(w, metric.name, metric.help, metric.kind)
+		for _, t := range tenants {
+			for _, e := range t.events {
+				writeSample(w, metric.name, seriesLabels(t.tnt, e.Name, nil), metric.value(e))
+			}
+		}
+	}
+
+	writeHeader(w, "analytics_event_properties_total", "Number of times an event was tracked with the property values.", "counter")
+	for _, t := range tenants {
+		for _, e := range t.events {
+			for _, p := range t.properties[e.Name] {
+				writeSample(w, "analytics_event_properties_total", seriesLabels(t.tnt, e.Name, p.Properties), float64(p.Value))
+			}
+		}
+	}
+
+	writeHeader(w, "analytics_events_filtered_total", "Number of times an event was tracked by a bot, by whether it was dropped or counted.", "counter")
+	for _, t := range tenants {
+		for _, e := range t.filtered {
+			writeSample(w, "analytics_events_filtered_total", seriesLabels(t.tnt, e.Name, e.Properties), float64(e.Value))
+		}
+	}
+
+	writeHeader(w, "analytics_metrics_dropped", "Number of events, property combinations and records of a tenant left out by the cardinality limits.", "gauge")
+	for _, t := range tenants {
+		writeSample(w, "analytics_metrics_dropped", seriesLabels(t.tnt, "", nil), float64(t.dropped))
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+
+	pb "analytics/proto"
+)
+
+// legacyShard is the shard given to records written before counts were sharded
+const legacyShard = "legacy"
+
+// legacySegments is the number of segments following the event name in
+// each kind of legacy key, before the optional shard
+var legacySegments = map[string]int{
+	kindEvent:     0,
+	kindSeries:    2,
+	kindDimension: 1,
+}
+
+// migratedKey is the store key of the marker written once the legacy
+// records have been migrated
+var migratedKey = keyPrefix(kindMigration) + legacyShard
+
+// Migrate rewrites the records stored before keys were namespaced into
+// the current layout. It's safe to run on every start and from several
+// replicas at once as legacy records always map to the same key. The
+// whole store is only listed until a run succeeds, so replicas of older
+// versions must be stopped before the first start.
+func Migrate() error {
+	if _, err := store.Read(migratedKey); err == nil {
+		return nil
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	var migrated int
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, keyVersion+separator) {
+			continue
+		}
+
+		recs, err := store.Read(key)
+		if err == store.ErrNotFound {
+			// another replica got to it first
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		r, err := decodeRecord(recs[0])
+		if err != nil || len(r.Name) == 0 {
+			logger.Warnf("Skipping migration of unknown record %s", key)
+			continue
+		}
+
+		newKey, ok := migrateKey(key, r.Event)
+		if !ok {
+			logger.Warnf("Skipping migration of unknown record %s", key)
+			continue
+		}
+
+		// copy the record as is, older values are upgraded when decoded
+		rec := &store.Record{
+			Key:    newKey,
+			Value:  recs[0].Value,
+			Expiry: recs[0].Expiry,
+		}
+		if err := store.Write(rec); err != nil {
+			return err
+		}
+		if err := store.Delete(key); err != nil && err != store.ErrNotFound {
+			return err
+		}
+
+		migrated++
+	}
+
+	if migrated > 0 {
+		logger.Infof("Migrated %d records to the %s key layout", migrated, keyVersion)
+	}
+
+	return store.Write(store.NewRecord(migratedKey, map[string]int{"migrated": migrated}))
+}
+
+// migrateKey returns the current key of a record stored at a legacy key.
+// Legacy keys joined the tenant, the event name and any further segments
+// with colons, e.g acme:click or series:acme:click:hour:202203151300,
+// optionally followed by the shard which wrote them.
+func migrateKey(key string, event *pb.Event) (string, bool) {
+	kind := kindEvent
+	for _, k := range []string{kindSeries, kindDimension} {
+		if strings.HasPrefix(key, k+":") {
+			kind = k
+			key = strings.TrimPrefix(key, k+":")
+			break
+		}
+	}
+
+	// tenants never contain a colon but event names may
+	i := strings.Index(key, ":")
+	if i < 0 {
+		return "", false
+	}
+
+	tnt, rest := key[:i], key[i+1:]
+	if !strings.HasPrefix(rest, event.Name) {
+		return "", false
+	}
+	rest = strings.TrimPrefix(rest, event.Name)
+
+	var segments []string
+	if len(rest) > 0 {
+		if !strings.HasPrefix(rest, ":") {
+			return "", false
+		}
+		segments = strings.Split(rest[1:], ":")
+	}
+
+	shard := legacyShard
+	switch n := legacySegments[kind]; len(segments) {
+	case n:
+	case n + 1:
+		shard = segments[n]
+		segments = segments[:n]
+	default:
+		return "", false
+	}
+
+	segments = append([]string{tnt, event.Name}, segments...)
+
+	return shardKey(keyPrefix(kind, segments...), shard), true
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/config/env"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/micro/v3/service/store/memory"
+
+	pb "analytics/proto"
+)
+
+func TestMigrateKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		name string
+		want string
+		ok   bool
+	}{
+		{"acme:click", "click", shardKey(keyPrefix(kindEvent, "acme", "click"), legacyShard), true},
+		{"ac/me:click", "click", shardKey(keyPrefix(kindEvent, "ac/me", "click"), legacyShard), true},
+		{"acme:a:b", "a:b", shardKey(keyPrefix(kindEvent, "acme", "a:b"), legacyShard), true},
+		{"acme:click:r1", "click", shardKey(keyPrefix(kindEvent, "acme", "click"), "r1"), true},
+		{"acme:a:b:r1", "a:b", shardKey(keyPrefix(kindEvent, "acme", "a:b"), "r1"), true},
+		{
+			"series:acme:click:hour:202203151300", "click",
+			shardKey(keyPrefix(kindSeries, "acme", "click", "hour", "202203151300"), legacyShard), true,
+		},
+		{
+			"series:acme:click:hour:202203151300:r1", "click",
+			shardKey(keyPrefix(kindSeries, "acme", "click", "hour", "202203151300"), "r1"), true,
+		},
+		{
+			"dims:acme:click:page=/pricing", "click",
+			shardKey(keyPrefix(kindDimension, "acme", "click", "page=/pricing"), legacyShard), true,
+		},
+		{
+			"dims:acme:click:page=/pricing:r1", "click",
+			shardKey(keyPrefix(kindDimension, "acme", "click", "page=/pricing"), "r1"), true,
+		},
+		// the name of the record doesn't match the key
+		{"acme:view", "click", "", false},
+		{"acme:clicks", "click", "", false},
+		// no tenant
+		{"click", "click", "", false},
+		// more segments than the kind has
+		{"acme:click:r1:r2", "click", "", false},
+		{"series:acme:click:hour", "click", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := migrateKey(tt.key, &pb.Event{Name: tt.name})
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("migrateKey(%q, %q) = %q, %v, want %q, %v", tt.key, tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	// an event counted before amounts, keys and shards
+	legacy := store.NewRecord("default:click", &pb.Event{Name: "click", Value: 3, Created: "2022-03-15T13:00:00Z"})
+	if err := store.Write(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if _, err := store.Read(legacy.Key); err != store.ErrNotFound {
+		t.Errorf("Expected the legacy record to be deleted, got %v", err)
+	}
+
+	rsp := &pb.ReadResponse{}
+	if err := New().Read(context.Background(), &pb.ReadRequest{Name: "click"}, rsp); err != nil {
+		t.Fatalf("Error reading event: %v", err)
+	}
+	if rsp.Event.Value != 3 || rsp.Event.Sum != 3 {
+		t.Errorf("Expected value 3 and sum 3, got %d and %v", rsp.Event.Value, rsp.Event.Sum)
+	}
+
+	// migrating again is a no-op
+	if err := Migrate(); err != nil {
+		t.Fatalf("Error migrating again: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// mmdbMarker precedes the metadata at the end of a MaxMind DB file
+var mmdbMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// types of the values in the data section
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// maxMMDBDepth is the deepest nesting of maps, arrays and pointers decoded,
+// so a corrupt file can't recurse endlessly
+const maxMMDBDepth = 32
+
+// mmdb reads a MaxMind DB file, as documented at
+// https://maxmind.github.io/MaxMind-DB/, held in memory
+type mmdb struct {
+	buf []byte
+	// data is the data section, which pointers are relative to
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node IPv4 addresses start at in an IPv6 tree
+	ipv4Start uint
+}
+
+// openMMDB reads a MaxMind DB file
+func openMMDB(path string) (*mmdb, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.LastIndex(buf, mmdbMarker)
+	if i < 0 {
+		return nil, errors.New("invalid MaxMind DB file, missing metadata")
+	}
+
+	db := &mmdb{buf: buf}
+
+	v, _, err := db.decode(buf[i+len(mmdbMarker):], 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+
+	db.nodeCount = metaUint(meta["node_count"])
+	db.recordSize = metaUint(meta["record_size"])
+	db.ipVersion = metaUint(meta["ip_version"])
+
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip version %d", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("invalid MaxMind DB file, truncated search tree")
+	}
+	db.data = buf[treeSize+16 : i]
+
+	// IPv4 addresses are stored as ::a.b.c.d in IPv6 trees
+	if db.ipVersion == 6 {
+		for n := 0; n < 96 && db.ipv4Start < db.nodeCount; n++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+
+	return db, nil
+}
+
+// metaUint returns a metadata value as a uint
+func metaUint(v interface{}) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case int64:
+		return uint(n)
+	}
+	return 0
+}
+
+// record returns the left (0) or right (1) record of a node
+func (db *mmdb) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		b := db.buf[node*8+bit*4:]
+		return uint(binary.BigEndian.Uint32(b))
+	}
+}
+
+// lookup returns the data of the network containing an ip, or nil if
+// there's none
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, errors.New("IPv6 address in an IPv4 database")
+	}
+
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, errors.New("invalid search tree")
+	}
+
+	offset := node - db.nodeCount - 16
+	if offset >= uint(len(db.data)) {
+		return nil, errors.New("invalid search tree")
+	}
+
+	v, _, err := db.decode(db.data, offset, 0)
+	return v, err
+}
+
+// decode decodes the value at offset of a section, nested depth values
+// deep, and returns the offset following it
+func (db *mmdb) decode(section []byte, offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxMMDBDepth {
+		return nil, 0, errors.New("data nested too deep")
+	}
+
+	next := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(section)) {
+			return nil, errors.New("unexpected end of data")
+		}
+		b := section[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	kind := uint(ctrl >> 5)
+
+	if kind == mmdbPointer {
+		ss, vvv := uint(ctrl>>3)&0x3, uint(ctrl&0x7)
+		b, err := next(ss + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var p uint
+		switch ss {
+		case 0:
+			p = vvv<<8 | uint(b[0])
+		case 1:
+			p = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+
+		// pointers always point into the data section, and never to
+		// another pointer
+		if p >= uint(len(db.data)) {
+			return nil, 0, errors.New("invalid pointer")
+		}
+		if uint(db.data[p]>>5) == mmdbPointer {
+			return nil, 0, errors.New("pointer to a pointer")
+		}
+		v, _, err := db.decode(db.data, p, depth+1)
+		return v, offset, err
+	}
+
+	if kind == mmdbExtended {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(b[0])
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		b, err := next(size - 28)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch size {
+		case 29:
+			size = 29 + uint(b[0])
+		case 30:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+
+	// every entry takes at least a byte
+	if (kind == mmdbMap || kind == mmdbArray) && size > uint(len(section))-offset {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+
+	switch kind {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, o, err := db.decode(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid map key")
+			}
+			v, o, err := db.decode(section, o, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = o
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, o, err := db.decode(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = o
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+
+	b, err = next(size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch kind {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes, mmdbUint128:
+		return append([]byte{}, b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case mmdbInt32:
+		var n int32
+		for _, c := range b {
+			n = n<<8 | int32(c)
+		}
+		return int64(n), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type %d", kind)
+}
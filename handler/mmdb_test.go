@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mmdbValue encodes the control byte, size and payload of a value of the
+// data section
+func mmdbValue(kind uint, size int, payload []byte) []byte {
+	var b bytes.Buffer
+
+	ctrl := byte(kind << 5)
+	if kind > 7 {
+		ctrl = 0
+	}
+
+	switch {
+	case size < 29:
+		b.WriteByte(ctrl | byte(size))
+	case size < 285:
+		b.WriteByte(ctrl | 29)
+	case size < 65821:
+		b.WriteByte(ctrl | 30)
+	default:
+		b.WriteByte(ctrl | 31)
+	}
+	if kind > 7 {
+		b.WriteByte(byte(kind - 7))
+	}
+
+	switch {
+	case size < 29:
+	case size < 285:
+		b.WriteByte(byte(size - 29))
+	case size < 65821:
+		b.Write([]byte{byte((size - 285) >> 8), byte(size - 285)})
+	default:
+		n := size - 65821
+		b.Write([]byte{byte(n >> 16), byte(n >> 8), byte(n)})
+	}
+
+	b.Write(payload)
+	return b.Bytes()
+}
+
+func mmdbText(s string) []byte {
+	return mmdbValue(mmdbString, len(s), []byte(s))
+}
+
+func mmdbUint(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return mmdbValue(mmdbUint32, 4, b)
+}
+
+// mmdbPointerTo encodes a pointer to an offset of the data section
+func mmdbPointerTo(offset uint) []byte {
+	return []byte{byte(mmdbPointer<<5 | offset>>8), byte(offset)}
+}
+
+// mmdbMapOf encodes a map of encoded values, sorted by key
+func mmdbMapOf(m map[string][]byte) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var payload []byte
+	for _, k := range keys {
+		payload = append(payload, mmdbText(k)...)
+		payload = append(payload, m[k]...)
+	}
+	return mmdbValue(mmdbMap, len(m), payload)
+}
+
+// fixtureNetwork is a network of a fixture and the offset of its data
+type fixtureNetwork struct {
+	cidr   string
+	offset uint
+}
+
+// writeMMDB writes a MaxMind DB file mapping networks to the values of a
+// data section and returns its path
+func writeMMDB(t *testing.T, recordSize, ipVersion int, data []byte, networks []fixtureNetwork) string {
+	// the search tree, a node being its left and right records, -1 for
+	// no data and below -1 for the data at -2-offset
+	nodes := [][2]int{{-1, -1}}
+
+	for _, n := range networks {
+		_, network, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := network.IP
+		ones, _ := network.Mask.Size()
+		if ipVersion == 6 && ip.To4() != nil {
+			ip = append(make(net.IP, 12), ip.To4()...)
+			ones += 96
+		}
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -2 - int(n.offset)
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	count := uint(len(nodes))
+	value := func(r int) uint {
+		switch {
+		case r == -1:
+			return count
+		case r < -1:
+			return count + 16 + uint(-2-r)
+		}
+		return uint(r)
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		l, r := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 28:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>24)<<4 | byte(r>>24), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 32:
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint32(b, uint32(l))
+			binary.BigEndian.PutUint32(b[4:], uint32(r))
+			buf.Write(b)
+		}
+	}
+
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.Write(mmdbMarker)
+	buf.Write(mmdbMapOf(map[string][]byte{
+		"node_count":  mmdbUint(uint32(count)),
+		"record_size": mmdbUint(uint32(recordSize)),
+		"ip_version":  mmdbUint(uint32(ipVersion)),
+	}))
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// mmdbFixture is a data section with a record per network, including a
+// string long enough for a two byte size and a pointer
+type mmdbFixture struct {
+	data     []byte
+	networks []fixtureNetwork
+}
+
+func newMMDBFixture() *mmdbFixture {
+	f := &mmdbFixture{}
+
+	add := func(cidr string, v []byte) uint {
+		offset := uint(len(f.data))
+		f.data = append(f.data, v...)
+		if len(cidr) > 0 {
+			f.networks = append(f.networks, fixtureNetwork{cidr: cidr, offset: offset})
+		}
+		return offset
+	}
+
+	country := add("", mmdbMapOf(map[string][]byte{"iso_code": mmdbText("GB")}))
+
+	add("1.2.3.0/24", mmdbMapOf(map[string][]byte{
+		"country": mmdbPointerTo(country),
+		"city":    mmdbMapOf(map[string][]byte{"names": mmdbMapOf(map[string][]byte{"en": mmdbText("London")})}),
+		"note":    mmdbText(strings.Repeat("x", 300)),
+	}))
+
+	// a map holding a pointer to itself
+	loop := uint(len(f.data))
+	add("6.6.6.0/24", mmdbMapOf(map[string][]byte{"self": mmdbPointerTo(loop)}))
+
+	// a pointer to itself
+	self := uint(len(f.data))
+	add("7.7.7.0/24", mmdbMapOf(map[string][]byte{"self": mmdbPointerTo(self + 6)}))
+
+	return f
+}
+
+func TestMMDBLookup(t *testing.T) {
+	f := newMMDBFixture()
+
+	for _, recordSize := range []int{24, 28, 32} {
+		for _, ipVersion := range []int{4, 6} {
+			path := writeMMDB(t, recordSize, ipVersion, f.data, f.networks)
+
+			db, err := openMMDB(path)
+			if err != nil {
+				t.Fatalf("Error opening %d bit IPv%d database: %v", recordSize, ipVersion, err)
+			}
+
+			v, err := db.lookup(net.ParseIP("1.2.3.4"))
+			if err != nil {
+				t.Fatalf("Error looking up in %d bit IPv%d database: %v", recordSize, ipVersion, err)
+			}
+			if s := lookupName(v, "country", "iso_code"); s != "GB" {
+				t.Errorf("Expected country GB in %d bit IPv%d database, got %q", recordSize, ipVersion, s)
+			}
+			if s := lookupName(v, "city", "names", "en"); s != "London" {
+				t.Errorf("Expected city London in %d bit IPv%d database, got %q", recordSize, ipVersion, s)
+			}
+			if s, _ := lookupValue(v, "note").(string); len(s) != 300 {
+				t.Errorf("Expected a note of 300 characters in %d bit IPv%d database, got %d", recordSize, ipVersion, len(s))
+			}
+
+			if v, err := db.lookup(net.ParseIP("5.6.7.8")); err != nil || v != nil {
+				t.Errorf("Expected no data for an unknown address in %d bit IPv%d database, got %v %v", recordSize, ipVersion, v, err)
+			}
+
+			for _, ip := range []string{"6.6.6.6", "7.7.7.7"} {
+				if _, err := db.lookup(net.ParseIP(ip)); err == nil {
+					t.Errorf("Expected an error looking up a self referencing pointer at %s in %d bit IPv%d database", ip, recordSize, ipVersion)
+				}
+			}
+		}
+	}
+}
+
+func TestGeoIPLocate(t *testing.T) {
+	f := newMMDBFixture()
+	path := writeMMDB(t, 24, 6, f.data, f.networks)
+
+	g, err := OpenGeoIP(path, false)
+	if err != nil {
+		t.Fatalf("Error opening GeoIP database: %v", err)
+	}
+
+	props := g.locate("1.2.3.4")
+	if props["country"] != "GB" || props["city"] != "London" {
+		t.Errorf("Expected GB and London, got %v", props)
+	}
+
+	if props := g.locate("5.6.7.8"); len(props) != 0 {
+		t.Errorf("Expected no properties for an unknown address, got %v", props)
+	}
+}
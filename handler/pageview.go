@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// pageViewEvent is the name of the event tracking page views
+const pageViewEvent = "pageview"
+
+// maxTop is the maximum number of results of the Top reports
+const maxTop = 1000
+
+// kinds of referrer
+const (
+	referrerDirect   = "direct"
+	referrerInternal = "internal"
+	referrerSearch   = "search"
+	referrerSocial   = "social"
+	referrerDomain   = "domain"
+)
+
+// utmKeys are the campaign parameters recorded as properties
+var utmKeys = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// searchEngines are the domains of search engines. Names ending with a
+// dot match every top level domain e.g google.co.uk
+var searchEngines = []string{
+	"google.", "bing.com", "duckduckgo.com", "yahoo.", "baidu.com",
+	"yandex.", "ecosia.org", "search.brave.com", "startpage.com", "qwant.com",
+}
+
+// socialNetworks are the domains of social networks
+var socialNetworks = []string{
+	"facebook.com", "instagram.com", "t.co", "twitter.com", "x.com",
+	"linkedin.com", "lnkd.in", "reddit.com", "youtube.com", "pinterest.com",
+	"news.ycombinator.com", "tiktok.com", "mastodon.social",
+}
+
+// reports are the property keys each Top report groups page views by
+var reports = map[string][]string{
+	"pages":     {"page"},
+	"referrers": {"referrer_type", "referrer"},
+	"campaigns": {"utm_source", "utm_medium", "utm_campaign"},
+}
+
+// normalisePath returns the path of a page without duplicate or trailing
+// slashes and with relative segments resolved
+func normalisePath(p string) string {
+	if len(p) == 0 {
+		return "/"
+	}
+	return path.Clean("/" + p)
+}
+
+// matchesDomain returns whether host is one of the domains or a subdomain of one
+func matchesDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if strings.HasSuffix(d, ".") {
+			// any top level domain e.g google.
+			if strings.HasPrefix(host, d) || strings.Contains(host, "."+d) {
+				return true
+			}
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyReferrer returns the kind of a referrer and its domain, given
+// the host of the page it linked to
+func classifyReferrer(referrer, host string) (string, string) {
+	u, err := url.Parse(referrer)
+	if err != nil || len(u.Hostname()) == 0 {
+		return referrerDirect, ""
+	}
+
+	domain := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	switch {
+	case domain == strings.TrimPrefix(host, "www."):
+		return referrerInternal, domain
+	case matchesDomain(domain, searchEngines):
+		return referrerSearch, domain
+	case matchesDomain(domain, socialNetworks):
+		return referrerSocial, domain
+	default:
+		return referrerDomain, domain
+	}
+}
+
+// pageViewProperties returns the properties of a page view of a url
+// coming from a referrer
+func pageViewProperties(pageURL, referrer string) (map[string]string, bool) {
+	u, err := url.Parse(pageURL)
+	if err != nil || (len(u.Host) == 0 && len(u.Path) == 0) {
+		return nil, false
+	}
+
+	host := strings.ToLower(u.Hostname())
+
+	props := map[string]string{
+		"page": normalisePath(u.Path),
+	}
+	if len(host) > 0 {
+		props["host"] = host
+	}
+
+	q := u.Query()
+	for _, k := range utmKeys {
+		if v := q.Get(k); len(v) > 0 {
+			props[k] = v
+		}
+	}
+
+	kind, domain := classifyReferrer(referrer, host)
+	props["referrer_type"] = kind
+	if len(domain) > 0 {
+		props["referrer"] = domain
+	}
+
+	return props, true
+}
+
+// PageView tracks a view of a page
+func (a *Analytics) PageView(ctx context.Context, req *pb.PageViewRequest, rsp *pb.PageViewResponse) error {
+	// Validate the request
+	if len(req.Url) == 0 {
+		return errors.BadRequest("analytics.pageview", "missing url")
+	}
+
+	props, ok := pageViewProperties(req.Url, req.Referrer)
+	if !ok {
+		return errors.BadRequest("analytics.pageview", "invalid url")
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	track := &pb.TrackRequest{
+		Name:       pageViewEvent,
+		Properties: props,
+		DistinctId: req.DistinctId,
+		UserAgent:  req.UserAgent,
+		Ip:         req.Ip,
+	}
+
+	// Record the page view once the request is done
+	defer func() {
+		if _, err := a.track(tnt, track, time.Now().UTC(), false); err != nil {
+			logger.Errorf("Error tracking page view of %s: %v", req.Url, err)
+		}
+	}()
+
+	return nil
+}
+
+// Top returns the pages, referrers or campaigns with the most page views
+func (a *Analytics) Top(ctx context.Context, req *pb.TopRequest, rsp *pb.TopResponse) error {
+	// Validate the request
+	keys, ok := reports[req.Report]
+	if !ok {
+		return errors.BadRequest("analytics.top", "invalid report, must be pages, referrers or campaigns")
+	}
+
+	if req.Limit < 0 || req.Limit > maxTop {
+		return errors.BadRequest("analytics.top", "limit must be between 0 and %d", maxTop)
+	}
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	groups, err := breakdown(tnt, pageViewEvent, keys)
+	if err != nil {
+		return errors.InternalServerError("analytics.top", "Error reading from store: %v", err.Error())
+	}
+
+	rsp.Results = []*pb.Event{}
+	for _, g := range groups {
+		// page views without any of the properties e.g not from a campaign
+		if len(g.Properties) == 0 {
+			continue
+		}
+		// navigation within the site isn't a referral
+		if g.Properties["referrer_type"] == referrerInternal {
+			continue
+		}
+		if len(rsp.Results) == int(req.Limit) {
+			break
+		}
+		rsp.Results = append(rsp.Results, g)
+	}
+
+	return nil
+}
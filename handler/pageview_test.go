@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifyReferrer(t *testing.T) {
+	tests := []struct {
+		referrer string
+		host     string
+		kind     string
+		domain   string
+	}{
+		{"", "example.com", referrerDirect, ""},
+		{"not a url", "example.com", referrerDirect, ""},
+		{"https://www.google.com/search?q=analytics", "example.com", referrerSearch, "google.com"},
+		{"https://www.google.co.uk/", "example.com", referrerSearch, "google.co.uk"},
+		{"https://WWW.Bing.COM/search?q=analytics", "example.com", referrerSearch, "bing.com"},
+		{"https://duckduckgo.com/", "example.com", referrerSearch, "duckduckgo.com"},
+		{"https://search.yahoo.co.jp/search", "example.com", referrerSearch, "search.yahoo.co.jp"},
+		{"https://search.brave.com/search?q=analytics", "example.com", referrerSearch, "search.brave.com"},
+		{"https://t.co/abc123", "example.com", referrerSocial, "t.co"},
+		{"https://m.facebook.com/", "example.com", referrerSocial, "m.facebook.com"},
+		{"https://news.ycombinator.com/item?id=1", "example.com", referrerSocial, "news.ycombinator.com"},
+		{"https://www.example.com/pricing", "example.com", referrerInternal, "example.com"},
+		{"https://example.com/", "www.example.com", referrerInternal, "example.com"},
+		{"https://blog.example.com/", "example.com", referrerDomain, "blog.example.com"},
+		{"https://notgoogle.com/", "example.com", referrerDomain, "notgoogle.com"},
+		{"https://brave.com/", "example.com", referrerDomain, "brave.com"},
+	}
+
+	for _, tt := range tests {
+		kind, domain := classifyReferrer(tt.referrer, tt.host)
+		if kind != tt.kind || domain != tt.domain {
+			t.Errorf("classifyReferrer(%q, %q) = %s, %q, want %s, %q", tt.referrer, tt.host, kind, domain, tt.kind, tt.domain)
+		}
+	}
+}
+
+func TestPageViewProperties(t *testing.T) {
+	tests := []struct {
+		url      string
+		referrer string
+		props    map[string]string
+		ok       bool
+	}{
+		{
+			url:      "https://Example.com/pricing/?utm_source=news&utm_medium=email&utm_campaign=launch&ref=x",
+			referrer: "https://www.google.com/",
+			props: map[string]string{
+				"page":          "/pricing",
+				"host":          "example.com",
+				"utm_source":    "news",
+				"utm_medium":    "email",
+				"utm_campaign":  "launch",
+				"referrer_type": referrerSearch,
+				"referrer":      "google.com",
+			},
+			ok: true,
+		},
+		{
+			url:      "https://example.com",
+			referrer: "https://example.com/blog",
+			props: map[string]string{
+				"page":          "/",
+				"host":          "example.com",
+				"referrer_type": referrerInternal,
+				"referrer":      "example.com",
+			},
+			ok: true,
+		},
+		{
+			url:      "https://example.com/blog/post",
+			referrer: "https://www.reddit.com/r/golang",
+			props: map[string]string{
+				"page":          "/blog/post",
+				"host":          "example.com",
+				"referrer_type": referrerSocial,
+				"referrer":      "reddit.com",
+			},
+			ok: true,
+		},
+		{
+			url: "/docs//intro/../start",
+			props: map[string]string{
+				"page":          "/docs/start",
+				"referrer_type": referrerDirect,
+			},
+			ok: true,
+		},
+		{url: "", ok: false},
+		{url: "://example.com", ok: false},
+	}
+
+	for _, tt := range tests {
+		props, ok := pageViewProperties(tt.url, tt.referrer)
+		if ok != tt.ok || (ok && !reflect.DeepEqual(props, tt.props)) {
+			t.Errorf("pageViewProperties(%q, %q) = %v, %v, want %v, %v", tt.url, tt.referrer, props, ok, tt.props, tt.ok)
+		}
+	}
+}
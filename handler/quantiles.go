@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// maxQuantiles is the maximum number of quantiles returned by Quantiles
+const maxQuantiles = 20
+
+// maxQuantileRange is the longest range of Quantiles
+const maxQuantileRange = 366 * 24 * time.Hour
+
+// defaultQuantiles are returned if none are requested
+var defaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// span is a time bucket of a granularity
+type span struct {
+	g     granularity
+	start time.Time
+}
+
+// cover returns the fewest time buckets covering the minutes from start
+// until end, using the largest buckets which fit. Buckets which have
+// expired are replaced by the larger ones containing them.
+func cover(start, end, now time.Time) []span {
+	var spans []span
+
+	for t := start.Truncate(time.Minute); t.Before(end); {
+		var s *span
+
+		// the largest bucket starting at t which doesn't go past end
+		for j := len(granularities) - 1; j >= 0; j-- {
+			g := granularities[j]
+			if !t.Truncate(g.size).Equal(t) || t.Add(g.size).After(end) || g.expired(t, now) {
+				continue
+			}
+			s = &span{g: g, start: t}
+			break
+		}
+
+		// otherwise the smallest bucket containing t which hasn't expired
+		if s == nil {
+			for _, g := range granularities {
+				if start := t.Truncate(g.size); !g.expired(start, now) {
+					s = &span{g: g, start: start}
+					break
+				}
+			}
+		}
+
+		spans = append(spans, *s)
+		t = s.start.Add(s.g.size)
+	}
+
+	return spans
+}
+
+// expired returns whether the bucket starting at t has expired by now
+func (g granularity) expired(t, now time.Time) bool {
+	return g.expiry > 0 && now.Sub(t.Add(g.size)) > g.expiry
+}
+
+// Quantiles estimates the quantiles of the amounts tracked with an Event
+// over a range by merging the sketches of its time buckets
+func (a *Analytics) Quantiles(ctx context.Context, req *pb.QuantilesRequest, rsp *pb.QuantilesResponse) error {
+	// Validate the request
+	if len(req.Name) == 0 {
+		return errors.BadRequest("analytics.quantiles", "missing name")
+	}
+
+	if len(req.Quantiles) == 0 {
+		req.Quantiles = defaultQuantiles
+	}
+	if len(req.Quantiles) > maxQuantiles {
+		return errors.BadRequest("analytics.quantiles", "too many quantiles, at most %d are allowed", maxQuantiles)
+	}
+	for _, q := range req.Quantiles {
+		if !(q >= 0 && q <= 1) {
+			return errors.BadRequest("analytics.quantiles", "quantiles must be between 0 and 1")
+		}
+	}
+
+	now := time.Now().UTC()
+
+	start, end, err := parseRange("analytics.quantiles", req.Start, req.End, 24*time.Hour, nil)
+	if err != nil {
+		return err
+	}
+
+	if !start.Before(end) {
+		return errors.BadRequest("analytics.quantiles", "start must be before end")
+	}
+	if end.Sub(start) > maxQuantileRange {
+		return errors.BadRequest("analytics.quantiles", "range exceeds %d days", maxQuantileRange/(24*time.Hour))
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	// merge the shards of every bucket in the range
+	spans := cover(start, end, now)
+	total := newRecord(&pb.Event{Name: req.Name})
+
+	for _, s := range spans {
+		records, err := readRecords(bucketKey(tnt, req.Name, s.g, s.start))
+		if err != nil && err != store.ErrNotFound {
+			return errors.InternalServerError("analytics.quantiles", "Error reading from store: %v", err.Error())
+		}
+		for _, r := range records {
+			// only the amounts counted in a sketch
+			if r.Digest == nil {
+				continue
+			}
+			total.merge(r)
+		}
+	}
+
+	rsp.RelativeError = sketchAccuracy
+	rsp.Start = spans[0].start.Format(time.RFC3339)
+	last := spans[len(spans)-1]
+	rsp.End = last.start.Add(last.g.size).Format(time.RFC3339)
+
+	if total.Digest == nil || total.Digest.Count == 0 {
+		rsp.Quantiles = []*pb.Quantile{}
+		return nil
+	}
+
+	rsp.Count = total.Digest.Count
+	for _, q := range req.Quantiles {
+		rsp.Quantiles = append(rsp.Quantiles, &pb.Quantile{
+			Quantile: q,
+			Value:    clamp(total.Digest.quantile(q), total.Min, total.Max),
+		})
+	}
+
+	return nil
+}
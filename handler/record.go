@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"math"
+
+	"github.com/micro/micro/v3/service/store"
+
+	pb "analytics/proto"
+)
+
+// record is the value stored for an Event. It holds the sketches which
+// aren't returned by the API alongside the Event's fields.
+type record struct {
+	*pb.Event
+	// Sketch estimates the distinct ids which triggered the event
+	Sketch *hll `json:"sketch,omitempty"`
+	// LastTime is when the last amount of a gauge was tracked, in unix
+	// nanoseconds, so the latest one wins across shards
+	LastTime int64 `json:"last_time,omitempty"`
+	// Histogram counts the amounts of a histogram declared with buckets
+	Histogram *histogram `json:"histogram,omitempty"`
+	// Digest estimates the quantiles of the amounts of a histogram
+	Digest *ddsketch `json:"digest,omitempty"`
+}
+
+func newRecord(event *pb.Event) *record {
+	return &record{Event: event}
+}
+
+// decodeRecord decodes the record stored in a store record
+func decodeRecord(rec *store.Record) (*record, error) {
+	r := newRecord(&pb.Event{})
+	if err := rec.Decode(r); err != nil {
+		return nil, err
+	}
+
+	// events tracked before amounts were supported counted 1 each time,
+	// unlike gauges and histograms which record amounts of 0
+	if r.Value > 0 && r.Sum == 0 && r.Min == 0 && r.Max == 0 && len(r.Type) == 0 {
+		r.Sum = float64(r.Value)
+		r.Min = 1
+		r.Max = 1
+		r.Mean = 1
+	}
+
+	return r, nil
+}
+
+// merge adds the counts and sketches of o into r
+func (r *record) merge(o *record) {
+	merge(r.Event, o.Event)
+
+	if o.Sketch != nil {
+		if r.Sketch == nil {
+			r.Sketch = newHLL()
+		}
+		r.Sketch.merge(o.Sketch)
+	}
+
+	if r.Sketch != nil {
+		r.Uniques = r.Sketch.count()
+	}
+
+	if o.LastTime > 0 && o.LastTime >= r.LastTime {
+		r.Last = o.Last
+		r.LastTime = o.LastTime
+	}
+
+	if o.Histogram != nil {
+		if r.Histogram == nil {
+			r.Histogram = newHistogram(o.Histogram.Bounds)
+		}
+		r.Histogram.merge(o.Histogram)
+	}
+
+	if o.Digest != nil {
+		if r.Digest == nil {
+			r.Digest = newDDSketch()
+		}
+		r.Digest.merge(o.Digest)
+	}
+
+	r.summarise()
+}
+
+// summarise sets the distribution of the amounts of a histogram
+func (r *record) summarise() {
+	switch {
+	case r.Histogram != nil:
+		r.Buckets = r.Histogram.buckets(r.Min, r.Max)
+		r.P50 = r.Histogram.quantile(0.5, r.Min, r.Max)
+		r.P90 = r.Histogram.quantile(0.9, r.Min, r.Max)
+		r.P99 = r.Histogram.quantile(0.99, r.Min, r.Max)
+	case r.Digest != nil:
+		r.P50 = clamp(r.Digest.quantile(0.5), r.Min, r.Max)
+		r.P90 = clamp(r.Digest.quantile(0.9), r.Min, r.Max)
+		r.P99 = clamp(r.Digest.quantile(0.99), r.Min, r.Max)
+	}
+}
+
+// finite returns whether an amount is a number which can be stored,
+// unlike NaN and the infinities
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// clamp returns v limited to the range from min to max
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// merge adds the counts of src into dst
+func merge(dst, src *pb.Event) {
+	if len(src.Created) > 0 && (len(dst.Created) == 0 || src.Created < dst.Created) {
+		dst.Created = src.Created
+	}
+	if len(src.Type) > 0 {
+		dst.Type = src.Type
+	}
+	dst.Late += src.Late
+	if src.Value == 0 {
+		return
+	}
+	if dst.Value == 0 || src.Min < dst.Min {
+		dst.Min = src.Min
+	}
+	if dst.Value == 0 || src.Max > dst.Max {
+		dst.Max = src.Max
+	}
+	dst.Value += src.Value
+	dst.Sum += src.Sum
+	dst.Mean = dst.Sum / float64(dst.Value)
+}
+
+// readRecords returns the records stored under prefix
+func readRecords(prefix string) ([]*record, error) {
+	recs, err := store.Read(prefix, store.ReadPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*record, 0, len(recs))
+	for _, rec := range recs {
+		r, err := decodeRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// addShard sums a shard into the total of the record it's part of, keyed
+// by an id unique to the record
+func addShard(totals map[string]*record, id string, shard *record) {
+	total, ok := totals[id]
+	if !ok {
+		total = newRecord(&pb.Event{Name: shard.Name, Properties: shard.Properties})
+		totals[id] = total
+	}
+	total.merge(shard)
+}
+
+// sumByName sums the shards of every event, keyed by name
+func sumByName(shards []*record) map[string]*record {
+	totals := map[string]*record{}
+	for _, shard := range shards {
+		addShard(totals, shard.Name, shard)
+	}
+	return totals
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// activityExpiry is how long the users active on a day are kept
+const activityExpiry = 366 * 24 * time.Hour
+
+// period is the size of a cohort in days
+type period struct {
+	name string
+	days int
+}
+
+var periods = []period{
+	{name: "day", days: 1},
+	{name: "week", days: 7},
+}
+
+// findPeriod returns the period with the given name
+func findPeriod(name string) (period, bool) {
+	for _, p := range periods {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return period{}, false
+}
+
+// start returns the start of the period containing t. Weeks start on Monday.
+func (p period) start(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if p.days == 7 {
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// activityPrefix returns the store prefix of all the users who triggered an event
+func activityPrefix(tnt, name string) string {
+	return keyPrefix(kindActivity, tnt, name)
+}
+
+// activityPartitions is the number of partitions the users active on a
+// day are split into by the top bits of their hash, so tracking an event
+// rewrites a fraction of them
+const activityPartitions = 256
+
+// activityKey returns the store prefix of the users who triggered an event during a day
+func activityKey(tnt, name string, day time.Time) string {
+	return keyPrefix(kindActivity, tnt, name, day.Format(dayFormat))
+}
+
+// activityPartitionKey returns the store prefix of the partition of the
+// users who triggered an event during a day which holds the hash of an id
+func activityPartitionKey(tnt, name string, day time.Time, x uint64) string {
+	partition := fmt.Sprintf("%02x", x/(math.MaxUint64/activityPartitions+1))
+	return keyPrefix(kindActivity, tnt, name, day.Format(dayFormat), partition)
+}
+
+// addActivity is a pending change to the users stored at a key
+type addActivity struct {
+	ids *idSet
+}
+
+// apply adds the ids to the set stored at key
+func (a *addActivity) apply(key string) error {
+	ids := newIDSet()
+
+	recs, err := store.Read(key)
+	if err == nil {
+		if err := recs[0].Decode(ids); err != nil {
+			return err
+		}
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	ids.merge(a.ids)
+
+	rec := &store.Record{Key: key, Expiry: activityExpiry}
+	if err := rec.Encode(ids); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// readActivity returns the users who triggered an event during the period
+// starting at start, reading every partition of every day
+func readActivity(tnt, name string, p period, start time.Time) (*idSet, error) {
+	ids := newIDSet()
+
+	for day := 0; day < p.days; day++ {
+		recs, err := store.Read(activityKey(tnt, name, start.AddDate(0, 0, day)), store.ReadPrefix())
+		if err != nil {
+			return nil, err
+		}
+
+		// merge the shards
+		for _, rec := range recs {
+			shard := newIDSet()
+			if err := rec.Decode(shard); err != nil {
+				return nil, err
+			}
+			ids.merge(shard)
+		}
+	}
+
+	return ids, nil
+}
+
+// Retention returns the share of users returning in the periods after they started
+func (a *Analytics) Retention(ctx context.Context, req *pb.RetentionRequest, rsp *pb.RetentionResponse) error {
+	// Validate the request
+	if len(req.StartEvent) == 0 {
+		return errors.BadRequest("analytics.retention", "missing start event")
+	}
+	if len(req.ReturnEvent) == 0 {
+		return errors.BadRequest("analytics.retention", "missing return event")
+	}
+
+	if len(req.Period) == 0 {
+		req.Period = "day"
+	}
+
+	p, ok := findPeriod(req.Period)
+	if !ok {
+		return errors.BadRequest("analytics.retention", "invalid period")
+	}
+
+	if req.Periods < 0 {
+		return errors.BadRequest("analytics.retention", "invalid periods")
+	}
+	if req.Periods == 0 {
+		req.Periods = 7
+	}
+
+	now := time.Now().UTC()
+
+	start, end, err := parseRange("analytics.retention", req.Start, req.End, time.Duration(6*p.days)*24*time.Hour, p.start)
+	if err != nil {
+		return err
+	}
+	if end.AddDate(0, 0, (int(req.Periods)+1)*p.days).Sub(start) > activityExpiry {
+		return errors.BadRequest("analytics.retention", "range and periods exceed %d days", activityExpiry/(24*time.Hour))
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	// users already counted in an earlier cohort
+	seen := newIDSet()
+	// users returning in each period, read once
+	returns := map[time.Time]*idSet{}
+
+	for c := start; !c.After(end); c = c.AddDate(0, 0, p.days) {
+		started, err := readActivity(tnt, req.StartEvent, p, c)
+		if err != nil {
+			return errors.InternalServerError("analytics.retention", "Error reading from store: %v", err.Error())
+		}
+
+		// users belong to the first cohort they started in
+		cohort := newIDSet()
+		for x := range started.ids {
+			if !seen.has(x) {
+				cohort.add(x)
+			}
+		}
+		seen.merge(cohort)
+
+		result := &pb.Cohort{
+			Start: c.Format(time.RFC3339),
+			Users: cohort.count(),
+		}
+
+		// follow the cohort until now, starting with its own period
+		for i := 0; i <= int(req.Periods); i++ {
+			t := c.AddDate(0, 0, i*p.days)
+			if t.After(now) {
+				break
+			}
+
+			returned, ok := returns[t]
+			if !ok {
+				returned, err = readActivity(tnt, req.ReturnEvent, p, t)
+				if err != nil {
+					return errors.InternalServerError("analytics.retention", "Error reading from store: %v", err.Error())
+				}
+				returns[t] = returned
+			}
+
+			var n uint64
+			for x := range cohort.ids {
+				if returned.has(x) {
+					n++
+				}
+			}
+
+			var share float64
+			if result.Users > 0 {
+				share = float64(n) / float64(result.Users)
+			}
+
+			result.Returned = append(result.Returned, n)
+			result.Retention = append(result.Retention, share)
+		}
+
+		rsp.Cohorts = append(rsp.Cohorts, result)
+	}
+
+	return nil
+}
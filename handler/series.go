@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// maxPoints is the maximum number of points returned by Series
+const maxPoints = 1440
+
+// bucketFormat is the sortable time format used in bucket keys
+const bucketFormat = "200601021504"
+
+// granularity describes the size of a time bucket and how long it's kept
+type granularity struct {
+	name   string
+	size   time.Duration
+	expiry time.Duration
+}
+
+// granularities are the time buckets written by Track
+var granularities = []granularity{
+	{name: "minute", size: time.Minute, expiry: 48 * time.Hour},
+	{name: "hour", size: time.Hour, expiry: 90 * 24 * time.Hour},
+	{name: "day", size: 24 * time.Hour},
+}
+
+// findGranularity returns the granularity with the given name
+func findGranularity(name string) (granularity, bool) {
+	for _, g := range granularities {
+		if g.name == name {
+			return g, true
+		}
+	}
+	return granularity{}, false
+}
+
+// bucketKey returns the store prefix of the bucket starting at t
+func bucketKey(tnt, name string, g granularity, t time.Time) string {
+	return keyPrefix(kindSeries, tnt, name, g.name, t.Format(bucketFormat))
+}
+
+// bucketPrefix returns the store prefix of all the buckets of an event
+func bucketPrefix(tnt, name string, g granularity) string {
+	return keyPrefix(kindSeries, tnt, name, g.name)
+}
+
+// Series returns the values of an Event over time
+func (a *Analytics) Series(ctx context.Context, req *pb.SeriesRequest, rsp *pb.SeriesResponse) error {
+	// Validate the request
+	if len(req.Name) == 0 {
+		return errors.BadRequest("analytics.series", "missing name")
+	}
+
+	if len(req.Granularity) == 0 {
+		req.Granularity = "hour"
+	}
+
+	g, ok := findGranularity(req.Granularity)
+	if !ok {
+		return errors.BadRequest("analytics.series", "invalid granularity")
+	}
+
+	start, end, err := parseRange("analytics.series", req.Start, req.End, 23*g.size, func(t time.Time) time.Time {
+		return t.Truncate(g.size)
+	})
+	if err != nil {
+		return err
+	}
+	if end.Sub(start)/g.size >= maxPoints {
+		return errors.BadRequest("analytics.series", "range exceeds %d points", maxPoints)
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	// Read and sum the shards of every bucket of the range, including
+	// the empty ones
+	for t := start; !t.After(end); t = t.Add(g.size) {
+		records, err := readRecords(bucketKey(tnt, req.Name, g, t))
+		if err != nil && err != store.ErrNotFound {
+			return errors.InternalServerError("analytics.series", "Error reading from store: %v", err.Error())
+		}
+
+		event := newRecord(&pb.Event{})
+		for _, r := range records {
+			event.merge(r)
+		}
+
+		rsp.Points = append(rsp.Points, &pb.Point{
+			Timestamp: t.Format(time.RFC3339),
+			Value:     event.Value,
+			Sum:       event.Sum,
+			Min:       event.Min,
+			Max:       event.Max,
+			Mean:      event.Mean,
+			Uniques:   event.Uniques,
+			Last:      event.Last,
+			P50:       event.P50,
+			P90:       event.P90,
+			P99:       event.P99,
+		})
+	}
+
+	return nil
+}
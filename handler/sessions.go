@@ -0,0 +1,547 @@
+package handler
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// sessionExpiry is how long the statistics of the sessions of a day are kept
+const sessionExpiry = 366 * 24 * time.Hour
+
+// maxSessionEvents is the maximum number of entry and exit events returned
+const maxSessionEvents = 1000
+
+// session is part of a visit of a user, the events a replica tracked
+// without the user being inactive for the session timeout. Replicas behind
+// a load balancer each track parts of the same visit, which are merged
+// into a single session once every part is inactive.
+type session struct {
+	DistinctId string `json:"distinct_id"`
+	// unix times of the first and last events in seconds
+	Start int64 `json:"start"`
+	Last  int64 `json:"last"`
+	// names of the first and last events
+	Entry  string `json:"entry"`
+	Exit   string `json:"exit"`
+	Events uint64 `json:"events"`
+	// Ended is set once the visit the part belongs to was counted
+	Ended bool `json:"ended,omitempty"`
+}
+
+// sessionParts are the parts of the visits of a user a replica tracked,
+// kept in the replica's shard until they've been counted
+type sessionParts struct {
+	DistinctId string     `json:"distinct_id"`
+	Sessions   []*session `json:"sessions"`
+}
+
+// endedTimeouts is the number of session timeouts parts are kept for after
+// their last event, so the replicas holding the other parts of their
+// visit see it was counted
+const endedTimeouts = 3
+
+// decodeSessionParts decodes the parts of the visits of a user, including
+// the single open session stored by previous versions
+func decodeSessionParts(rec *store.Record) (*sessionParts, error) {
+	parts := &sessionParts{}
+	if err := rec.Decode(parts); err != nil {
+		return nil, err
+	}
+	if len(parts.Sessions) > 0 {
+		return parts, nil
+	}
+
+	var s session
+	if err := rec.Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Events > 0 {
+		parts.Sessions = []*session{&s}
+	}
+
+	return parts, nil
+}
+
+// readSessionParts returns the parts of the visits of a user stored at key,
+// or none if there are none yet
+func readSessionParts(key, id string) (*sessionParts, error) {
+	recs, err := store.Read(key)
+	if err == store.ErrNotFound {
+		return &sessionParts{DistinctId: id}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return decodeSessionParts(recs[0])
+}
+
+// write stores the parts at key, or deletes it if there are none left
+func (p *sessionParts) write(key string) error {
+	if len(p.Sessions) == 0 {
+		if err := store.Delete(key); err != nil && err != store.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+
+	rec := &store.Record{Key: key}
+	if err := rec.Encode(p); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// sessionStats sums up the sessions which started during a day
+type sessionStats struct {
+	Sessions uint64 `json:"sessions"`
+	// Duration is the total duration of the sessions in seconds
+	Duration int64  `json:"duration"`
+	Events   uint64 `json:"events"`
+	// Bounces counts the sessions with a single event
+	Bounces uint64            `json:"bounces"`
+	Entries map[string]uint64 `json:"entries"`
+	Exits   map[string]uint64 `json:"exits"`
+}
+
+func newSessionStats() *sessionStats {
+	return &sessionStats{
+		Entries: map[string]uint64{},
+		Exits:   map[string]uint64{},
+	}
+}
+
+// add counts a session which ended
+func (s *sessionStats) add(o *session) {
+	s.Sessions++
+	s.Duration += o.Last - o.Start
+	s.Events += o.Events
+	if o.Events == 1 {
+		s.Bounces++
+	}
+	s.Entries[o.Entry]++
+	s.Exits[o.Exit]++
+}
+
+// merge adds the counts of o into s
+func (s *sessionStats) merge(o *sessionStats) {
+	s.Sessions += o.Sessions
+	s.Duration += o.Duration
+	s.Events += o.Events
+	s.Bounces += o.Bounces
+	for k, v := range o.Entries {
+		s.Entries[k] += v
+	}
+	for k, v := range o.Exits {
+		s.Exits[k] += v
+	}
+}
+
+// sessionKey returns the store prefix of the open session of a user
+func sessionKey(tnt, id string) string {
+	return keyPrefix(kindSession, tnt, id)
+}
+
+// sessionStatsPrefix returns the store prefix of the statistics of the
+// sessions which started during a day
+func sessionStatsPrefix(tnt string, day time.Time) string {
+	return keyPrefix(kindSessionStats, tnt, day.Format(dayFormat))
+}
+
+// addSessions is a pending change to the statistics stored at a key
+type addSessions struct {
+	stats *sessionStats
+}
+
+// apply counts the sessions into the statistics stored at key
+func (a *addSessions) apply(key string) error {
+	stats := newSessionStats()
+
+	recs, err := store.Read(key)
+	if err == nil {
+		if err := recs[0].Decode(stats); err != nil {
+			return err
+		}
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	stats.merge(a.stats)
+
+	rec := &store.Record{Key: key, Expiry: sessionExpiry}
+	if err := rec.Encode(stats); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// countSessions counts sessions which ended into the statistics of the
+// days they started in, in the shard's records
+func countSessions(tnt, shard string, sessions ...*session) error {
+	days := map[string]*addSessions{}
+	var keys []string
+
+	for _, s := range sessions {
+		day := time.Unix(s.Start, 0).UTC()
+		key := shardKey(sessionStatsPrefix(tnt, day), shard)
+		if _, ok := days[key]; !ok {
+			days[key] = &addSessions{stats: newSessionStats()}
+			keys = append(keys, key)
+		}
+		days[key].stats.add(s)
+	}
+
+	for _, key := range keys {
+		if err := days[key].apply(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trackSession is a pending change to the parts of the visits of a user
+type trackSession struct {
+	id      string
+	timeout time.Duration
+	events  []*occurrence
+}
+
+// apply extends the part of a visit stored at key which each event is
+// within the timeout of, or starts a new one
+func (t *trackSession) apply(key string) error {
+	parts, err := readSessionParts(key, t.id)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(t.events, func(i, j int) bool {
+		return t.events[i].Time < t.events[j].Time
+	})
+
+	timeout := int64(t.timeout / time.Second)
+
+	for _, e := range t.events {
+		var part *session
+		for _, s := range parts.Sessions {
+			// visits which were counted aren't extended, even by late events
+			if !s.Ended && e.Time <= s.Last+timeout && e.Time >= s.Start-timeout {
+				part = s
+				break
+			}
+		}
+
+		if part == nil {
+			parts.Sessions = append(parts.Sessions, &session{
+				DistinctId: t.id,
+				Start:      e.Time,
+				Last:       e.Time,
+				Entry:      e.Name,
+				Exit:       e.Name,
+				Events:     1,
+			})
+			continue
+		}
+
+		part.merge(&session{Start: e.Time, Last: e.Time, Entry: e.Name, Exit: e.Name, Events: 1})
+	}
+
+	return parts.write(key)
+}
+
+// adoptSessions is a pending change moving the parts of the visits of a
+// user from the shard of a replica which stopped
+type adoptSessions struct {
+	parts *sessionParts
+}
+
+// apply adds the parts to those stored at key, they're merged when swept
+func (a *adoptSessions) apply(key string) error {
+	parts, err := readSessionParts(key, a.parts.DistinctId)
+	if err != nil {
+		return err
+	}
+
+	parts.Sessions = append(parts.Sessions, a.parts.Sessions...)
+
+	return parts.write(key)
+}
+
+// merge extends a session with the events of another part of the same visit
+func (s *session) merge(o *session) {
+	s.Events += o.Events
+	if o.Start < s.Start {
+		s.Start, s.Entry = o.Start, o.Entry
+	}
+	if o.Last >= s.Last {
+		s.Last, s.Exit = o.Last, o.Exit
+	}
+}
+
+// overlaps returns whether two parts are within the timeout of each other
+func (s *session) overlaps(o *session, timeout int64) bool {
+	return s.Start <= o.Last+timeout && o.Start <= s.Last+timeout
+}
+
+// sessionPart is a part of a visit and the shard it's kept in
+type sessionPart struct {
+	*session
+	shard string
+}
+
+// visit returns the parts of the visit a part belongs to, those within the
+// timeout of each other, with the earliest first
+func visit(parts []*sessionPart, part *session, timeout int64) []*sessionPart {
+	var v []*sessionPart
+	in := map[*session]bool{}
+
+	for grown := true; grown; {
+		grown = false
+		for _, p := range parts {
+			if in[p.session] {
+				continue
+			}
+			if p.session == part || anyOverlaps(v, p.session, timeout) {
+				v = append(v, p)
+				in[p.session] = true
+				grown = true
+			}
+		}
+	}
+
+	sort.Slice(v, func(i, j int) bool {
+		if v[i].Start != v[j].Start {
+			return v[i].Start < v[j].Start
+		}
+		if v[i].shard != v[j].shard {
+			return v[i].shard < v[j].shard
+		}
+		return v[i].Last < v[j].Last
+	})
+
+	return v
+}
+
+// anyOverlaps returns whether a part is within the timeout of any of the parts
+func anyOverlaps(parts []*sessionPart, s *session, timeout int64) bool {
+	for _, p := range parts {
+		if p.overlaps(s, timeout) {
+			return true
+		}
+	}
+	return false
+}
+
+// SweepSessions ends the sessions of the users inactive for the session
+// timeout every interval
+func (a *Analytics) SweepSessions(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := a.sweepSessions(time.Now().UTC()); err != nil {
+			logger.Errorf("Error ending sessions: %v", err)
+		}
+	}
+}
+
+// sweepSessions ends the sessions of every tenant in the replica's shard
+// inactive since before now minus the session timeout
+func (a *Analytics) sweepSessions(now time.Time) error {
+	keys, err := store.List(
+		store.ListPrefix(keyPrefix(kindSession)),
+		store.ListSuffix(separator+escape(a.shard)),
+	)
+	if err != nil {
+		return err
+	}
+
+	var n int
+
+	for _, key := range keys {
+		segments, ok := keySegments(kindSession, key)
+		if !ok || len(segments) != 3 || segments[2] != a.shard {
+			continue
+		}
+
+		ended, err := a.endSessions(segments[0], key, now)
+		if err != nil {
+			return err
+		}
+		n += ended
+	}
+
+	if n > 0 {
+		logger.Debugf("Ended %d inactive sessions", n)
+	}
+
+	return nil
+}
+
+// endSessions ends the visits of the parts stored at key once every part,
+// including those kept by other replicas, has been inactive since before
+// now minus the session timeout. The replica with the earliest part counts
+// the visit and the others mark theirs ended when they see it was. It
+// returns the number of sessions counted.
+func (a *Analytics) endSessions(tnt, key string, now time.Time) (int, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	recs, err := store.Read(shardPrefix(key), store.ReadPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	// the parts of every replica, the replica's own first
+	var own *sessionParts
+	var parts []*sessionPart
+
+	for _, rec := range recs {
+		segments, ok := keySegments(kindSession, rec.Key)
+		if !ok || len(segments) != 3 {
+			continue
+		}
+
+		p, err := decodeSessionParts(rec)
+		if err != nil {
+			if rec.Key == key {
+				logger.Warnf("Deleting invalid session %s", key)
+				return 0, store.Delete(key)
+			}
+			continue
+		}
+		if rec.Key == key {
+			own = p
+		}
+		for _, s := range p.Sessions {
+			parts = append(parts, &sessionPart{session: s, shard: segments[2]})
+		}
+	}
+	if own == nil {
+		return 0, nil
+	}
+
+	timeout := int64(a.sessionTimeout / time.Second)
+	inactive := func(s *session) bool { return now.Unix()-s.Last > timeout }
+
+	var n int
+	var kept []*session
+
+	for _, s := range own.Sessions {
+		if now.Unix()-s.Last > endedTimeouts*timeout {
+			continue
+		}
+		kept = append(kept, s)
+		if s.Ended || !inactive(s) {
+			continue
+		}
+
+		v := visit(parts, s, timeout)
+
+		var active, ended bool
+		for _, p := range v {
+			active = active || !inactive(p.session)
+			ended = ended || p.Ended
+		}
+
+		switch {
+		case active:
+			// the user is still active on another replica
+		case ended:
+			s.Ended = true
+		case v[0].session == s:
+			total := *v[0].session
+			for _, p := range v[1:] {
+				total.merge(p.session)
+			}
+			if err := countSessions(tnt, a.shard, &total); err != nil {
+				return 0, err
+			}
+			s.Ended = true
+			n++
+		}
+	}
+
+	own.Sessions = kept
+
+	return n, own.write(key)
+}
+
+// sessionEvents returns the events counted in a map, most frequent first
+func sessionEvents(counts map[string]uint64, limit int) []*pb.SessionEvent {
+	events := make([]*pb.SessionEvent, 0, len(counts))
+	for name, n := range counts {
+		events = append(events, &pb.SessionEvent{Name: name, Sessions: n})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Sessions != events[j].Sessions {
+			return events[i].Sessions > events[j].Sessions
+		}
+		return events[i].Name < events[j].Name
+	})
+
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events
+}
+
+// Sessions returns the statistics of the sessions which ended, by the day they started in
+func (a *Analytics) Sessions(ctx context.Context, req *pb.SessionsRequest, rsp *pb.SessionsResponse) error {
+	// Validate the request
+	start, end, err := parseRange("analytics.sessions", req.Start, req.End, 7*24*time.Hour, nil)
+	if err != nil {
+		return err
+	}
+	if end.Sub(start) > sessionExpiry {
+		return errors.BadRequest("analytics.sessions", "range exceeds %d days", sessionExpiry/(24*time.Hour))
+	}
+
+	if req.Limit < 0 || req.Limit > maxSessionEvents {
+		return errors.BadRequest("analytics.sessions", "limit must be between 0 and %d", maxSessionEvents)
+	}
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	// sum the shards of every day
+	total := newSessionStats()
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+		recs, err := store.Read(sessionStatsPrefix(tnt, day), store.ReadPrefix())
+		if err != nil {
+			return errors.InternalServerError("analytics.sessions", "Error reading from store: %v", err.Error())
+		}
+
+		for _, rec := range recs {
+			stats := newSessionStats()
+			if err := rec.Decode(stats); err != nil {
+				return errors.InternalServerError("analytics.sessions", "Error reading from store: %v", err.Error())
+			}
+			total.merge(stats)
+		}
+	}
+
+	rsp.Sessions = total.Sessions
+	if total.Sessions > 0 {
+		rsp.AverageDuration = float64(total.Duration) / float64(total.Sessions)
+		rsp.EventsPerSession = float64(total.Events) / float64(total.Sessions)
+		rsp.BounceRate = float64(total.Bounces) / float64(total.Sessions)
+	}
+	rsp.Entries = sessionEvents(total.Entries, int(req.Limit))
+	rsp.Exits = sessionEvents(total.Exits, int(req.Limit))
+
+	return nil
+}
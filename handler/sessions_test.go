@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/config/env"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/micro/v3/service/store/memory"
+
+	pb "analytics/proto"
+)
+
+func TestSweepSessionsAcrossReplicas(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	a, b := New(WithReplica("a")), New(WithReplica("b"))
+	start := time.Now().UTC().Truncate(24 * time.Hour).Add(time.Hour)
+
+	// a visit balanced across both replicas, then another one much later
+	visits := []struct {
+		replica *Analytics
+		name    string
+		at      time.Duration
+	}{
+		{a, "land", 0},
+		{b, "browse", time.Minute},
+		{a, "buy", 2 * time.Minute},
+		{b, "share", 20 * time.Minute},
+		{b, "land", 3 * time.Hour},
+	}
+	for _, v := range visits {
+		batch := v.replica.newBatch()
+		batch.track(0, "default", &pb.TrackItem{Name: v.name, DistinctId: "alice"}, start.Add(v.at), false, nil)
+		if errs := batch.commit(); len(errs) > 0 {
+			t.Fatalf("Error tracking event: %v", errs[0])
+		}
+	}
+
+	sweep := func(r *Analytics, at time.Duration) {
+		if err := r.sweepSessions(start.Add(at)); err != nil {
+			t.Fatalf("Error sweeping sessions: %v", err)
+		}
+	}
+
+	// only the replica with the earliest part counts the visit, whichever
+	// sweeps first, and not while the user is active on the other
+	sweep(b, time.Hour)
+	sweep(a, 40*time.Minute)
+	sweep(a, time.Hour)
+	sweep(b, time.Hour)
+	sweep(a, 4*time.Hour)
+	sweep(b, 4*time.Hour)
+
+	stats, err := readSessionStats("default", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sessions != 2 || stats.Events != 5 || stats.Duration != 1200 || stats.Bounces != 1 {
+		t.Errorf("Expected 2 sessions of 5 events lasting 1200s with a bounce, got %+v", stats)
+	}
+	if stats.Entries["land"] != 2 || stats.Exits["share"] != 1 || stats.Exits["land"] != 1 {
+		t.Errorf("Expected both sessions to start with land and end with share and land, got %v and %v", stats.Entries, stats.Exits)
+	}
+
+	// the parts are deleted once every replica would see they were counted
+	sweep(a, 8*time.Hour)
+	sweep(b, 8*time.Hour)
+	if keys, err := store.List(store.ListPrefix(keyPrefix(kindSession))); err != nil || len(keys) != 0 {
+		t.Errorf("Expected no open sessions, got %v %v", keys, err)
+	}
+}
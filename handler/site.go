@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// maxDomains is the maximum number of domains allowed per site
+const maxDomains = 50
+
+// maxSiteEvents is the maximum number of events allowed per site
+const maxSiteEvents = 100
+
+// siteRecord is the value stored for a Site
+type siteRecord struct {
+	*pb.Site
+	// Tenant the site tracks events for
+	Tenant string `json:"tenant"`
+}
+
+// siteKey returns the store key of a site of a tenant
+func siteKey(tnt, key string) string {
+	return keyPrefix(kindSite, tnt) + escape(key)
+}
+
+// lookupKey returns the store key resolving the tenant of a site key
+func lookupKey(key string) string {
+	return keyPrefix(kindSiteKey) + escape(key)
+}
+
+// readSite returns the site with a public key
+func readSite(key string) (*siteRecord, error) {
+	recs, err := store.Read(lookupKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	site := &siteRecord{Site: &pb.Site{}}
+	if err := recs[0].Decode(site); err != nil {
+		return nil, err
+	}
+
+	return site, nil
+}
+
+// allows returns whether the site allows tracking events from a page
+// with the given host
+func (s *siteRecord) allows(host string) bool {
+	if len(s.Domains) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, d := range s.Domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tracks returns whether the site allows tracking an event
+func (s *siteRecord) tracks(name string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+
+	for _, e := range s.Events {
+		if e == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normaliseDomain returns the domain of an allowlist entry, accepting
+// entries such as https://Example.com/ too
+func normaliseDomain(d string) (string, bool) {
+	d = strings.ToLower(strings.TrimSpace(d))
+	if strings.Contains(d, "://") {
+		u, err := url.Parse(d)
+		if err != nil {
+			return "", false
+		}
+		d = u.Hostname()
+	}
+	d = strings.TrimSuffix(d, "/")
+
+	if len(d) == 0 || strings.ContainsAny(d, "/:?#@ ") {
+		return "", false
+	}
+
+	return d, true
+}
+
+// CreateSite creates a site key to track events from browsers
+func (a *Analytics) CreateSite(ctx context.Context, req *pb.CreateSiteRequest, rsp *pb.CreateSiteResponse) error {
+	// Validate the request
+	if len(req.Domains) > maxDomains {
+		return errors.BadRequest("analytics.createsite", "too many domains, at most %d are allowed", maxDomains)
+	}
+
+	domains := make([]string, 0, len(req.Domains))
+	for _, d := range req.Domains {
+		domain, ok := normaliseDomain(d)
+		if !ok {
+			return errors.BadRequest("analytics.createsite", "invalid domain %s", d)
+		}
+		domains = append(domains, domain)
+	}
+
+	if len(req.Events) > maxSiteEvents {
+		return errors.BadRequest("analytics.createsite", "too many events, at most %d are allowed", maxSiteEvents)
+	}
+	for _, e := range req.Events {
+		if len(e) == 0 || len(e) > maxBeaconNameLength {
+			return errors.BadRequest("analytics.createsite", "invalid event %.20s", e)
+		}
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	site := &siteRecord{
+		Site: &pb.Site{
+			Key:     uuid.New().String(),
+			Domains: domains,
+			Events:  req.Events,
+			Created: time.Now().UTC().Format(time.RFC3339),
+		},
+		Tenant: tnt,
+	}
+
+	// write the lookup last so the key only works once the site is listed
+	for _, key := range []string{siteKey(tnt, site.Key), lookupKey(site.Key)} {
+		if err := store.Write(store.NewRecord(key, site)); err != nil {
+			return errors.InternalServerError("analytics.createsite", "Error writing to store: %v", err.Error())
+		}
+	}
+
+	rsp.Site = site.Site
+
+	return nil
+}
+
+// ListSites returns the sites of the tenant
+func (a *Analytics) ListSites(ctx context.Context, req *pb.ListSitesRequest, rsp *pb.ListSitesResponse) error {
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	recs, err := store.Read(keyPrefix(kindSite, tnt), store.ReadPrefix())
+	if err != nil {
+		return errors.InternalServerError("analytics.listsites", "Error reading from store: %v", err.Error())
+	}
+
+	rsp.Sites = make([]*pb.Site, 0, len(recs))
+	for _, rec := range recs {
+		site := &siteRecord{Site: &pb.Site{}}
+		if err := rec.Decode(site); err != nil {
+			return errors.InternalServerError("analytics.listsites", "Error decoding site: %v", err.Error())
+		}
+		rsp.Sites = append(rsp.Sites, site.Site)
+	}
+
+	return nil
+}
+
+// DeleteSite deletes a site so its key can't track events anymore
+func (a *Analytics) DeleteSite(ctx context.Context, req *pb.DeleteSiteRequest, rsp *pb.DeleteSiteResponse) error {
+	// Validate the request
+	if len(req.Key) == 0 {
+		return errors.BadRequest("analytics.deletesite", "missing key")
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	// only delete the sites of the tenant
+	if _, err := store.Read(siteKey(tnt, req.Key)); err == store.ErrNotFound {
+		return errors.NotFound("analytics.deletesite", "Site not found")
+	} else if err != nil {
+		return errors.InternalServerError("analytics.deletesite", "Error reading from store: %v", err.Error())
+	}
+
+	// delete the lookup first so the key stops working straight away
+	for _, key := range []string{lookupKey(req.Key), siteKey(tnt, req.Key)} {
+		if err := store.Delete(key); err != nil && err != store.ErrNotFound {
+			return errors.InternalServerError("analytics.deletesite", "Error deleting from store: %v", err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+
+	pb "analytics/proto"
+)
+
+// maxPacket is the size of the largest StatsD packet read
+const maxPacket = 65535
+
+// statsdLine is a parsed StatsD line
+type statsdLine struct {
+	// tenant set by tag, if any
+	tenant string
+	items  []*statsdItem
+}
+
+// statsdItem is an event tracked by a StatsD line
+type statsdItem struct {
+	*pb.TrackItem
+	// measurement counts the amount as the type of the metric
+	measurement *measurement
+	// delta is set for gauges changed by the amount rather than set to it
+	delta bool
+}
+
+// statsdTypes are the types the amounts of StatsD metrics are counted as,
+// unless their event is declared with another
+var statsdTypes = map[string]string{
+	"ms": typeHistogram,
+	"h":  typeHistogram,
+	"d":  typeHistogram,
+	"g":  typeGauge,
+}
+
+// parseStatsD parses a StatsD or DogStatsD line such as
+// page.views:1|c|@0.5|#page:/pricing into the events it tracks. The tag
+// named tenantTag, if any, picks the tenant instead of being a property.
+//
+// Counters track their value as the amount, scaled by the sample rate.
+// Timers, histograms and distributions track their value as the amount of
+// a histogram, counted as many times as it was sampled from, and gauges as
+// the amount of a gauge, changed by the value if it's signed. A value of
+// 0 counts as 0 unless the event is declared a counter.
+// Sets track the value as the distinct id so it's counted in uniques.
+func parseStatsD(line, tenantTag string) (*statsdLine, error) {
+	i := strings.Index(line, ":")
+	if i <= 0 {
+		return nil, errors.New("missing name")
+	}
+	name, rest := line[:i], line[i+1:]
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return nil, errors.New("missing type")
+	}
+
+	// DogStatsD packs several values in a line
+	values := strings.Split(fields[0], ":")
+	kind := fields[1]
+
+	rate := 1.0
+	props := map[string]string{}
+	var tnt string
+
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			r, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return nil, errors.New("invalid sample rate")
+			}
+			rate = r
+		case strings.HasPrefix(f, "#"):
+			for _, tag := range strings.Split(f[1:], ",") {
+				if len(tag) == 0 {
+					continue
+				}
+				k, v := tag, ""
+				if j := strings.Index(tag, ":"); j >= 0 {
+					k, v = tag[:j], tag[j+1:]
+				}
+				if len(tenantTag) > 0 && k == tenantTag {
+					tnt = v
+					continue
+				}
+				props[k] = v
+			}
+		}
+	}
+
+	var m *measurement
+	switch kind {
+	case "ms", "h", "d":
+		// the amount stands for those which weren't sampled
+		m = &measurement{Type: statsdTypes[kind], Weight: uint64(math.Round(1 / rate))}
+	case "g":
+		m = &measurement{Type: statsdTypes[kind]}
+	}
+
+	l := &statsdLine{tenant: tnt}
+
+	for _, v := range values {
+		item := &statsdItem{
+			TrackItem:   &pb.TrackItem{Name: name, Properties: props},
+			measurement: m,
+		}
+
+		switch kind {
+		case "s":
+			if len(v) == 0 {
+				return nil, errors.New("missing value")
+			}
+			item.DistinctId = v
+		case "c", "ms", "h", "d", "g":
+			amount, err := strconv.ParseFloat(v, 64)
+			if err != nil || !finite(amount) {
+				return nil, errors.New("invalid value")
+			}
+			if kind == "c" {
+				if amount == 0 {
+					// nothing to count
+					continue
+				}
+				amount /= rate
+				if !finite(amount) {
+					return nil, errors.New("invalid value")
+				}
+			}
+			item.Amount = amount
+			item.delta = kind == "g" && (strings.HasPrefix(v, "+") || strings.HasPrefix(v, "-"))
+		default:
+			return nil, errors.New("unknown type " + kind)
+		}
+
+		l.items = append(l.items, item)
+	}
+
+	return l, nil
+}
+
+// ServeStatsD counts the StatsD lines received on a UDP address as events
+// of tnt. A line with a tag named tenantTag is counted for the tenant it
+// picks instead, only if the tenant is allowed as any sender can set it.
+func (a *Analytics) ServeStatsD(addr, tnt, tenantTag string, allowed []string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	allow := map[string]bool{}
+	for _, t := range allowed {
+		allow[strings.TrimSpace(t)] = true
+	}
+
+	buf := make([]byte, maxPacket)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		a.countStatsD(string(buf[:n]), tnt, tenantTag, allow)
+	}
+}
+
+// countStatsD counts the lines of a StatsD packet, those tagged with a
+// tenant which isn't allowed are skipped
+func (a *Analytics) countStatsD(packet, tnt, tenantTag string, allow map[string]bool) {
+	// the events of every tenant in the packet
+	items := map[string][]*statsdItem{}
+
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		l, err := parseStatsD(line, tenantTag)
+		if err != nil {
+			logger.Warnf("Skipping StatsD line %q: %v", line, err)
+			continue
+		}
+
+		t := tnt
+		if len(l.tenant) > 0 {
+			if !allow[l.tenant] {
+				logger.Warnf("Skipping StatsD line %q: tenant %s not allowed", line, l.tenant)
+				continue
+			}
+			t = l.tenant
+		}
+		items[t] = append(items[t], l.items...)
+	}
+
+	now := time.Now().UTC()
+
+	for t, events := range items {
+		b := a.newBatch()
+		names := make([]string, len(events))
+
+		// the gauges changed by the packet, so deltas add up
+		gauges := map[string]float64{}
+
+		for i, item := range events {
+			names[i] = item.Name
+
+			if item.delta {
+				last, ok := gauges[item.Name]
+				if !ok {
+					var err error
+					if last, err = readGauge(t, item.Name); err != nil {
+						logger.Errorf("Error reading gauge %s: %v", item.Name, err)
+						continue
+					}
+				}
+				if item.Amount += last; !finite(item.Amount) {
+					logger.Warnf("Skipping StatsD gauge %s: invalid value", item.Name)
+					continue
+				}
+			}
+			if item.measurement != nil && item.measurement.Type == typeGauge {
+				gauges[item.Name] = item.Amount
+			}
+
+			b.track(i, t, item.TrackItem, now, false, item.measurement)
+			if a.eventLog {
+				b.log(i, t, item.TrackItem, now, false, item.measurement)
+			}
+		}
+
+		a.lock.Lock()
+		errs := b.commit()
+		a.lock.Unlock()
+
+		if len(errs) > 0 {
+			logger.Errorf("Error counting %d of %d StatsD events of %s", len(errs), len(events), t)
+		}
+
+		a.notify(t, names...)
+	}
+}
+
+// readGauge returns the last amount of a gauge, or 0 if it wasn't tracked
+func readGauge(tnt, name string) (float64, error) {
+	event, err := readEvent(tnt, name)
+	if err == store.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return event.Last, nil
+}
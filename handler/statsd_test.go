@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/config/env"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/micro/v3/service/store/memory"
+
+	pb "analytics/proto"
+)
+
+func TestParseStatsD(t *testing.T) {
+	type item struct {
+		amount   float64
+		distinct string
+		typ      string
+		weight   uint64
+		delta    bool
+	}
+
+	tests := []struct {
+		line   string
+		tenant string
+		props  map[string]string
+		items  []item
+		err    bool
+	}{
+		{line: "views:1|c", items: []item{{amount: 1}}},
+		{line: "views:2|c|@0.5", items: []item{{amount: 4}}},
+		{line: "views:0|c", items: nil},
+		{line: "views:1:2|c", items: []item{{amount: 1}, {amount: 2}}},
+		{line: "latency:0|ms", items: []item{{amount: 0, typ: typeHistogram, weight: 1}}},
+		{line: "latency:320|ms|@0.1", items: []item{{amount: 320, typ: typeHistogram, weight: 10}}},
+		{line: "size:12|h|@0.25", items: []item{{amount: 12, typ: typeHistogram, weight: 4}}},
+		{line: "size:12|d", items: []item{{amount: 12, typ: typeHistogram, weight: 1}}},
+		{line: "queue:0|g", items: []item{{amount: 0, typ: typeGauge}}},
+		{line: "queue:7|g", items: []item{{amount: 7, typ: typeGauge}}},
+		{line: "queue:+3|g", items: []item{{amount: 3, typ: typeGauge, delta: true}}},
+		{line: "queue:-3|g", items: []item{{amount: -3, typ: typeGauge, delta: true}}},
+		{line: "users:alice|s", items: []item{{distinct: "alice"}}},
+		{
+			line:   "views:1|c|#page:/pricing,acme,tenant:acme",
+			tenant: "acme",
+			props:  map[string]string{"page": "/pricing", "acme": ""},
+			items:  []item{{amount: 1}},
+		},
+		{line: "views", err: true},
+		{line: ":1|c", err: true},
+		{line: "views:1", err: true},
+		{line: "views:x|c", err: true},
+		{line: "views:NaN|ms", err: true},
+		{line: "views:1e308|c|@1e-10", err: true},
+		{line: "views:1|c|@0", err: true},
+		{line: "views:1|c|@2", err: true},
+		{line: "users:|s", err: true},
+		{line: "views:1|x", err: true},
+	}
+
+	for _, tt := range tests {
+		l, err := parseStatsD(tt.line, "tenant")
+		if tt.err {
+			if err == nil {
+				t.Errorf("Expected an error parsing %q", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", tt.line, err)
+			continue
+		}
+
+		if l.tenant != tt.tenant {
+			t.Errorf("Expected tenant %q parsing %q, got %q", tt.tenant, tt.line, l.tenant)
+		}
+		if len(l.items) != len(tt.items) {
+			t.Errorf("Expected %d items parsing %q, got %d", len(tt.items), tt.line, len(l.items))
+			continue
+		}
+
+		for i, want := range tt.items {
+			got := l.items[i]
+			var typ string
+			var weight uint64
+			if got.measurement != nil {
+				typ, weight = got.measurement.Type, got.measurement.Weight
+			}
+			if got.Amount != want.amount || got.DistinctId != want.distinct || typ != want.typ ||
+				weight != want.weight || got.delta != want.delta {
+				t.Errorf("Expected %+v parsing %q, got amount %v, id %q, type %q, weight %d and delta %v",
+					want, tt.line, got.Amount, got.DistinctId, typ, weight, got.delta)
+			}
+			if tt.props != nil {
+				for k, v := range tt.props {
+					if got.Properties[k] != v {
+						t.Errorf("Expected property %s=%q parsing %q, got %v", k, v, tt.line, got.Properties)
+					}
+				}
+				if _, ok := got.Properties["tenant"]; ok {
+					t.Errorf("Expected the tenant tag not to be a property parsing %q", tt.line)
+				}
+			}
+		}
+	}
+}
+
+func TestCountStatsD(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	a := New()
+	a.countStatsD("queue:5|g\nqueue:+3|g\nqueue:-1|g\nlatency:0|ms\nlatency:100|ms|@0.5", "default", "", nil)
+
+	read := func(name string) *pb.Event {
+		rsp := &pb.ReadResponse{}
+		if err := a.Read(context.Background(), &pb.ReadRequest{Name: name}, rsp); err != nil {
+			t.Fatalf("Error reading %s: %v", name, err)
+		}
+		return rsp.Event
+	}
+
+	// signed gauges change the last value
+	if queue := read("queue"); queue.Last != 7 {
+		t.Errorf("Expected gauge 7, got %v", queue.Last)
+	}
+	a.countStatsD("queue:-2|g", "default", "", nil)
+	if queue := read("queue"); queue.Last != 5 {
+		t.Errorf("Expected gauge 5, got %v", queue.Last)
+	}
+
+	// timers of 0 are counted as 0 and sampled ones by their rate
+	latency := read("latency")
+	if latency.Value != 3 || latency.Sum != 200 || latency.Min != 0 {
+		t.Errorf("Expected 3 timings summing to 200 from 0, got %d summing to %v from %v", latency.Value, latency.Sum, latency.Min)
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// policies for timestamps outside of the accepted range
+const (
+	// policyReject fails to track the event
+	policyReject = "reject"
+	// policyClamp moves the event to the nearest accepted time
+	policyClamp = "clamp"
+	// policyLate counts the event in its totals but not in any time bucket
+	policyLate = "late"
+)
+
+var (
+	errInvalidTimestamp = errors.New("invalid timestamp")
+	errTimestampRange   = errors.New("timestamp out of range")
+)
+
+// timestampPolicy decides when the events clients supply timestamps for happened
+type timestampPolicy struct {
+	// maxSkew is how far in the future timestamps are accepted, 0 for any
+	maxSkew time.Duration
+	// maxAge is how far in the past timestamps are accepted, 0 for any
+	maxAge time.Duration
+	// policy applied to the timestamps out of range
+	policy string
+}
+
+// loadTimestampPolicy reads the timestamp policy from the config
+func loadTimestampPolicy() timestampPolicy {
+	p := timestampPolicy{
+		maxSkew: 5 * time.Minute,
+		maxAge:  72 * time.Hour,
+		policy:  policyClamp,
+	}
+
+	if v, err := config.Get("analytics.max_skew"); err == nil {
+		p.maxSkew = v.Duration(p.maxSkew)
+	}
+	if v, err := config.Get("analytics.max_age"); err == nil {
+		p.maxAge = v.Duration(p.maxAge)
+	}
+	if v, err := config.Get("analytics.late_policy"); err == nil {
+		p.policy = v.String(p.policy)
+	}
+
+	switch p.policy {
+	case policyReject, policyClamp, policyLate:
+	default:
+		logger.Fatalf("Invalid analytics.late_policy %q, must be reject, clamp or late", p.policy)
+	}
+
+	return p
+}
+
+// resolve returns the time an event happened given the timestamp supplied
+// by the client, and whether it must be counted as late
+func (p timestampPolicy) resolve(ts string, now time.Time) (time.Time, bool, error) {
+	if len(ts) == 0 {
+		return now, false, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return time.Time{}, false, errInvalidTimestamp
+	}
+	t = t.UTC()
+
+	bound := t
+	if p.maxSkew > 0 && t.After(now.Add(p.maxSkew)) {
+		bound = now.Add(p.maxSkew)
+	}
+	if p.maxAge > 0 && t.Before(now.Add(-p.maxAge)) {
+		bound = now.Add(-p.maxAge)
+	}
+	if bound.Equal(t) {
+		return t, false, nil
+	}
+
+	switch p.policy {
+	case policyClamp:
+		return bound, false, nil
+	case policyLate:
+		return now, true, nil
+	default:
+		return time.Time{}, false, errTimestampRange
+	}
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// maxTopValues is the number of most frequent values kept per property,
+// and the maximum returned by TopValues
+const maxTopValues = 100
+
+// heavyHitters estimates the most frequent values of a property without
+// keeping a count per value
+type heavyHitters struct {
+	Sketch *countMin `json:"sketch"`
+	// Top are the candidates for the most frequent values, with their
+	// estimated counts
+	Top map[string]uint64 `json:"top"`
+	// Total is the number of times the property was counted
+	Total uint64 `json:"total"`
+}
+
+func newHeavyHitters() *heavyHitters {
+	return &heavyHitters{
+		Sketch: &countMin{},
+		Top:    map[string]uint64{},
+	}
+}
+
+// offer makes a value a candidate if its estimated count is among the
+// highest, replacing the least frequent candidate once there are enough
+func (h *heavyHitters) offer(v string) {
+	est := h.Sketch.estimate(v)
+
+	if _, ok := h.Top[v]; ok || len(h.Top) < maxTopValues {
+		h.Top[v] = est
+		return
+	}
+
+	var least string
+	var min uint64 = math.MaxUint64
+	for c, n := range h.Top {
+		if n < min || (n == min && c > least) {
+			least, min = c, n
+		}
+	}
+
+	if est > min {
+		delete(h.Top, least)
+		h.Top[v] = est
+	}
+}
+
+// add counts a value n times
+func (h *heavyHitters) add(v string, n uint64) {
+	h.Sketch.add(v, n)
+	h.Total += n
+	h.offer(v)
+}
+
+// merge adds the counts of o, keeping the candidates of both which are
+// the most frequent once combined
+func (h *heavyHitters) merge(o *heavyHitters) {
+	h.Sketch.merge(o.Sketch)
+	h.Total += o.Total
+
+	for v := range h.Top {
+		h.Top[v] = h.Sketch.estimate(v)
+	}
+	for v := range o.Top {
+		h.offer(v)
+	}
+}
+
+// topValuesPrefix returns the store prefix of the heavy hitters of every property of an Event
+func topValuesPrefix(tnt, name string) string {
+	return keyPrefix(kindTopValues, tnt, name)
+}
+
+// topValuesKey returns the store prefix of the heavy hitters of a property of an Event
+func topValuesKey(tnt, name, property string) string {
+	return keyPrefix(kindTopValues, tnt, name, property)
+}
+
+// countValues is a pending change to the heavy hitters stored at a key
+type countValues struct {
+	values map[string]uint64
+}
+
+// apply counts the values into the heavy hitters stored at key
+func (c *countValues) apply(key string) error {
+	h := newHeavyHitters()
+
+	recs, err := store.Read(key)
+	if err == nil {
+		if err := recs[0].Decode(h); err != nil {
+			return err
+		}
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	for v, n := range c.values {
+		h.add(v, n)
+	}
+
+	rec := &store.Record{Key: key}
+	if err := rec.Encode(h); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// mergeValues is a pending change merging heavy hitters into those stored at a key
+type mergeValues struct {
+	hitters *heavyHitters
+}
+
+// apply merges the heavy hitters into those stored at key
+func (m *mergeValues) apply(key string) error {
+	h := newHeavyHitters()
+
+	recs, err := store.Read(key)
+	if err == nil {
+		if err := recs[0].Decode(h); err != nil {
+			return err
+		}
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	h.merge(m.hitters)
+
+	rec := &store.Record{Key: key}
+	if err := rec.Encode(h); err != nil {
+		return err
+	}
+
+	return store.Write(rec)
+}
+
+// TopValues returns the most frequent values of a property of an Event
+// with approximate counts, if it was declared with top values
+func (a *Analytics) TopValues(ctx context.Context, req *pb.TopValuesRequest, rsp *pb.TopValuesResponse) error {
+	// Validate the request
+	if len(req.Name) == 0 {
+		return errors.BadRequest("analytics.topvalues", "missing name")
+	}
+	if len(req.Property) == 0 {
+		return errors.BadRequest("analytics.topvalues", "missing property")
+	}
+
+	if req.Limit < 0 || req.Limit > maxTopValues {
+		return errors.BadRequest("analytics.topvalues", "limit must be between 0 and %d", maxTopValues)
+	}
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	typ, err := readType(tnt, req.Name)
+	if err != nil {
+		return errors.InternalServerError("analytics.topvalues", "Error reading from store: %v", err.Error())
+	}
+	if !typ.hasTopValues(req.Property) {
+		return errors.BadRequest("analytics.topvalues", "property not declared with top values")
+	}
+
+	recs, err := store.Read(topValuesKey(tnt, req.Name, req.Property), store.ReadPrefix())
+	if err != nil {
+		return errors.InternalServerError("analytics.topvalues", "Error reading from store: %v", err.Error())
+	}
+
+	// merge the shards
+	total := newHeavyHitters()
+	for _, rec := range recs {
+		h := newHeavyHitters()
+		if err := rec.Decode(h); err != nil {
+			return errors.InternalServerError("analytics.topvalues", "Error reading from store: %v", err.Error())
+		}
+		total.merge(h)
+	}
+
+	rsp.Total = total.Total
+	rsp.Error = uint64(math.Ceil(countMinError * float64(total.Total)))
+
+	rsp.Values = make([]*pb.TopValue, 0, len(total.Top))
+	for v, n := range total.Top {
+		rsp.Values = append(rsp.Values, &pb.TopValue{Value: v, Count: n})
+	}
+
+	sort.Slice(rsp.Values, func(i, j int) bool {
+		if rsp.Values[i].Count != rsp.Values[j].Count {
+			return rsp.Values[i].Count > rsp.Values[j].Count
+		}
+		return rsp.Values[i].Value < rsp.Values[j].Value
+	})
+
+	if len(rsp.Values) > int(req.Limit) {
+		rsp.Values = rsp.Values[:req.Limit]
+	}
+
+	return nil
+}
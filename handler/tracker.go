@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+
+	pb "analytics/proto"
+)
+
+// maxBeacon is the size of the largest beacon body read
+const maxBeacon = 64 << 10
+
+const (
+	// maxBeaconProperties is the number of properties an event sent by a
+	// browser may have
+	maxBeaconProperties = 20
+	// maxBeaconPropertyLength is the length of the longest property key
+	// or value of an event sent by a browser
+	maxBeaconPropertyLength = 200
+	// maxBeaconNameLength is the length of the longest name of an event
+	// sent by a browser
+	maxBeaconNameLength = 100
+	// maxBeaconIdLength is the length of the longest distinct id of an
+	// event sent by a browser
+	maxBeaconIdLength = 200
+)
+
+// propertyParam prefixes the query parameters of the pixel which are properties
+const propertyParam = "p_"
+
+// pixel is a transparent 1x1 GIF
+var pixel, _ = base64.StdEncoding.DecodeString("R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7")
+
+// beacon is the body of a beacon sent by a browser
+type beacon struct {
+	Key        string            `json:"key"`
+	Name       string            `json:"name"`
+	Amount     float64           `json:"amount"`
+	DistinctId string            `json:"distinct_id"`
+	Properties map[string]string `json:"properties"`
+	// URL and Referrer of the page, for page views
+	URL      string `json:"url"`
+	Referrer string `json:"referrer"`
+}
+
+// Tracker records the events sent by browsers, either as a pixel such as
+// /t.gif?k=<site key>&e=pageview&p=/pricing&p_plan=pro or as a beacon
+// POSTed to /t. Page views are recorded like PageView, from the url of the
+// page or the Referer and the referrer of the page passed as r.
+type Tracker struct {
+	analytics *Analytics
+	// trustedProxies are the networks whose X-Forwarded-For is honoured
+	trustedProxies []*net.IPNet
+}
+
+// NewTracker returns a Tracker recording events with a, trusting the
+// proxies set in the config
+func NewTracker(a *Analytics) *Tracker {
+	t := &Tracker{analytics: a}
+
+	if v, err := config.Get("analytics.tracker_trusted_proxies"); err == nil {
+		for _, cidr := range v.StringSlice(nil) {
+			if !strings.Contains(cidr, "/") {
+				if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+					cidr += "/32"
+				} else {
+					cidr += "/128"
+				}
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				logger.Warnf("Skipping invalid trusted proxy %s: %v", cidr, err)
+				continue
+			}
+			t.trustedProxies = append(t.trustedProxies, network)
+		}
+	}
+
+	return t
+}
+
+// pageHost returns the host of the page which sent a request
+func pageHost(r *http.Request) string {
+	for _, h := range []string{r.Referer(), r.Header.Get("Origin")} {
+		if u, err := url.Parse(h); err == nil && len(u.Hostname()) > 0 {
+			return u.Hostname()
+		}
+	}
+	return ""
+}
+
+// trusted returns whether an address is one of the trusted proxies
+func (t *Tracker) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the browser which sent a request. When
+// it comes from a trusted proxy that's the last hop of X-Forwarded-For
+// which isn't a trusted proxy, as anything before it can be forged.
+func (t *Tracker) clientIP(r *http.Request) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		addr = host
+	}
+	if !t.trusted(addr) {
+		return addr
+	}
+
+	var hops []string
+	for _, fwd := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(fwd, ",") {
+			if hop = strings.TrimSpace(hop); len(hop) > 0 {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	// walk back from the last hop, skipping the proxies
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr = hops[i]
+		if !t.trusted(addr) {
+			break
+		}
+	}
+
+	return addr
+}
+
+// checkProperties returns an error if there are too many properties or
+// one of them is too long
+func checkProperties(props map[string]string) error {
+	if len(props) > maxBeaconProperties {
+		return fmt.Errorf("too many properties, at most %d are allowed", maxBeaconProperties)
+	}
+	for k, v := range props {
+		if len(k) > maxBeaconPropertyLength || len(v) > maxBeaconPropertyLength {
+			return fmt.Errorf("property %.20s too long, at most %d characters are allowed", k, maxBeaconPropertyLength)
+		}
+	}
+	return nil
+}
+
+// checkEvent returns an error if the name or distinct id of an event is too long
+func checkEvent(name, id string) error {
+	if len(name) > maxBeaconNameLength {
+		return fmt.Errorf("name too long, at most %d characters are allowed", maxBeaconNameLength)
+	}
+	if len(id) > maxBeaconIdLength {
+		return fmt.Errorf("distinct id too long, at most %d characters are allowed", maxBeaconIdLength)
+	}
+	return nil
+}
+
+// ServeHTTP records an event
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// let pages on any origin send beacons, the site's domains are checked below
+	if origin := r.Header.Get("Origin"); len(origin) > 0 {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Cache-Control", "no-store")
+
+	var b beacon
+
+	switch {
+	case r.Method == http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/t.gif":
+		q := r.URL.Query()
+		b = beacon{
+			Key:        q.Get("k"),
+			Name:       q.Get("e"),
+			DistinctId: q.Get("u"),
+			Properties: map[string]string{},
+			URL:        q.Get("url"),
+			Referrer:   q.Get("r"),
+		}
+		if a := q.Get("a"); len(a) > 0 {
+			amount, err := strconv.ParseFloat(a, 64)
+			if err != nil || !finite(amount) {
+				http.Error(w, "invalid amount", http.StatusBadRequest)
+				return
+			}
+			b.Amount = amount
+		}
+		if p := q.Get("p"); len(p) > 0 {
+			b.Properties["page"] = normalisePath(p)
+		}
+		for k := range q {
+			if strings.HasPrefix(k, propertyParam) && len(k) > len(propertyParam) {
+				b.Properties[strings.TrimPrefix(k, propertyParam)] = q.Get(k)
+			}
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/t":
+		// beacons are usually sent as text/plain to avoid a preflight
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBeacon))
+		if err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(body, &b); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if len(b.Key) == 0 {
+			b.Key = r.URL.Query().Get("k")
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(b.Key) == 0 {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	if len(b.Name) == 0 {
+		b.Name = pageViewEvent
+	}
+	if !finite(b.Amount) {
+		http.Error(w, "invalid amount", http.StatusBadRequest)
+		return
+	}
+	if err := checkEvent(b.Name, b.DistinctId); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkProperties(b.Properties); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	site, err := readSite(b.Key)
+	if err == store.ErrNotFound {
+		http.Error(w, "unknown key", http.StatusForbidden)
+		return
+	} else if err != nil {
+		logger.Errorf("Error reading site %s: %v", b.Key, err)
+		http.Error(w, "Error reading from store", http.StatusInternalServerError)
+		return
+	}
+
+	if !site.allows(pageHost(r)) {
+		http.Error(w, "referer not allowed", http.StatusForbidden)
+		return
+	}
+	if !site.tracks(b.Name) {
+		http.Error(w, "event not allowed", http.StatusForbidden)
+		return
+	}
+
+	if b.Name == pageViewEvent {
+		pageURL := b.URL
+		if len(pageURL) == 0 {
+			pageURL = r.Referer()
+		}
+
+		// properties set explicitly take precedence
+		if props, ok := pageViewProperties(pageURL, b.Referrer); ok {
+			for k, v := range b.Properties {
+				props[k] = v
+			}
+			b.Properties = props
+		}
+	}
+
+	_, err = t.analytics.track(site.Tenant, &pb.TrackRequest{
+		Name:       b.Name,
+		Amount:     b.Amount,
+		Properties: b.Properties,
+		DistinctId: b.DistinctId,
+		UserAgent:  r.UserAgent(),
+		Ip:         t.clientIP(r),
+	}, time.Now().UTC(), false)
+	if err != nil {
+		logger.Errorf("Error tracking event %s: %v", b.Name, err)
+		http.Error(w, "Error tracking event", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Write(pixel)
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/micro/micro/v3/service/config"
+	"github.com/micro/micro/v3/service/config/env"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/micro/v3/service/store/memory"
+
+	pb "analytics/proto"
+)
+
+func TestTrackerLimits(t *testing.T) {
+	store.DefaultStore = memory.NewStore()
+	config.DefaultConfig, _ = env.NewConfig()
+
+	a := New()
+	site := &pb.CreateSiteResponse{}
+	if err := a.CreateSite(context.Background(), &pb.CreateSiteRequest{Events: []string{"signup"}}, site); err != nil {
+		t.Fatalf("Error creating site: %v", err)
+	}
+	tracker := NewTracker(a)
+
+	tests := []struct {
+		event string
+		id    string
+		code  int
+	}{
+		{"signup", "alice", http.StatusOK},
+		{"signup", strings.Repeat("u", maxBeaconIdLength+1), http.StatusBadRequest},
+		{strings.Repeat("e", maxBeaconNameLength+1), "alice", http.StatusBadRequest},
+		{"purchase", "alice", http.StatusForbidden},
+		// page views are only tracked if allowed too
+		{"", "alice", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		q := url.Values{"k": {site.Site.Key}, "e": {tt.event}, "u": {tt.id}}
+		w := httptest.NewRecorder()
+		tracker.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/t.gif?"+q.Encode(), nil))
+		if w.Code != tt.code {
+			t.Errorf("Expected %d tracking %.20s by %.20s, got %d", tt.code, tt.event, tt.id, w.Code)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+
+	pb "analytics/proto"
+)
+
+// types of event
+const (
+	// typeCounter adds up the tracked amounts
+	typeCounter = "counter"
+	// typeGauge also keeps the last tracked amount
+	typeGauge = "gauge"
+	// typeHistogram also keeps the distribution of the tracked amounts
+	typeHistogram = "histogram"
+)
+
+// maxBuckets is the maximum number of buckets of a histogram
+const maxBuckets = 100
+
+// maxTopValueProperties is the maximum number of properties of an event
+// whose top values are estimated
+const maxTopValueProperties = 10
+
+// eventType is the declared type of an event
+type eventType struct {
+	Type string `json:"type"`
+	// Buckets are the upper bounds of the buckets of a histogram, which
+	// keeps a ddsketch instead if there are none
+	Buckets []float64 `json:"buckets,omitempty"`
+	// TopValues are the properties whose most frequent values are
+	// estimated instead of being counted with the other properties
+	TopValues []string `json:"top_values,omitempty"`
+}
+
+// counter is the type of the events which weren't declared
+var counter = &eventType{Type: typeCounter}
+
+// equal returns whether two declarations are the same
+func (e *eventType) equal(o *eventType) bool {
+	if !e.sameShape(o) || len(e.TopValues) != len(o.TopValues) {
+		return false
+	}
+	for i, k := range e.TopValues {
+		if k != o.TopValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameShape returns whether the records of two declarations are the same
+func (e *eventType) sameShape(o *eventType) bool {
+	return e.Type == o.Type && (&histogram{Bounds: e.Buckets}).sameBounds(&histogram{Bounds: o.Buckets})
+}
+
+// hasTopValues returns whether the top values of a property are estimated
+func (e *eventType) hasTopValues(key string) bool {
+	for _, k := range e.TopValues {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// typeKey returns the store key of the type of an event
+func typeKey(tnt, name string) string {
+	return keyPrefix(kindType, tnt) + escape(name)
+}
+
+// readType returns the declared type of an event
+func readType(tnt, name string) (*eventType, error) {
+	recs, err := store.Read(typeKey(tnt, name))
+	if err == store.ErrNotFound {
+		return counter, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var typ eventType
+	if err := recs[0].Decode(&typ); err != nil {
+		return nil, err
+	}
+
+	return &typ, nil
+}
+
+// typeOf returns the declared type of an event, read once per batch. Events
+// are counted if their type can't be read.
+func (b *batch) typeOf(tnt, name string) *eventType {
+	key := typeKey(tnt, name)
+	if typ, ok := b.types[key]; ok {
+		return typ
+	}
+
+	typ, err := readType(tnt, name)
+	if err != nil {
+		logger.Errorf("Error reading type of %s: %v", name, err)
+		typ = counter
+	}
+	b.types[key] = typ
+
+	return typ
+}
+
+// sample returns what an amount tracked n times adds to the records of an
+// event of the type
+func (e *eventType) sample(delta *record, amount float64, n uint64, unixNano int64) {
+	switch e.Type {
+	case typeGauge:
+		delta.Last = amount
+		delta.LastTime = unixNano
+	case typeHistogram:
+		if len(e.Buckets) > 0 {
+			delta.Histogram = newHistogram(e.Buckets)
+			delta.Histogram.add(amount, n)
+		} else {
+			delta.Digest = newDDSketch()
+			delta.Digest.add(amount, n)
+		}
+	}
+}
+
+// declaredType validates a declaration
+func declaredType(req *pb.DeclareRequest) (*eventType, error) {
+	typ := &eventType{Type: req.Type}
+
+	if len(req.TopValues) > maxTopValueProperties {
+		return nil, errors.BadRequest("analytics.declare", "too many top values, at most %d properties are allowed", maxTopValueProperties)
+	}
+	seen := map[string]bool{}
+	for _, k := range req.TopValues {
+		if len(k) == 0 || seen[k] {
+			return nil, errors.BadRequest("analytics.declare", "top values must be distinct property keys")
+		}
+		seen[k] = true
+	}
+	typ.TopValues = append([]string{}, req.TopValues...)
+	sort.Strings(typ.TopValues)
+
+	switch req.Type {
+	case typeCounter, typeGauge:
+		if len(req.Buckets) > 0 || req.Exponential != nil {
+			return nil, errors.BadRequest("analytics.declare", "only histograms have buckets")
+		}
+		return typ, nil
+	case typeHistogram:
+	default:
+		return nil, errors.BadRequest("analytics.declare", "invalid type, must be counter, gauge or histogram")
+	}
+
+	if len(req.Buckets) > 0 && req.Exponential != nil {
+		return nil, errors.BadRequest("analytics.declare", "either buckets or exponential buckets are allowed")
+	}
+
+	if e := req.Exponential; e != nil {
+		if e.Start <= 0 || e.Factor <= 1 || e.Count < 1 {
+			return nil, errors.BadRequest("analytics.declare", "exponential buckets need a positive start, a factor above 1 and a count")
+		}
+		if e.Count > maxBuckets {
+			return nil, errors.BadRequest("analytics.declare", "too many buckets, at most %d are allowed", maxBuckets)
+		}
+		for i := 0; i < int(e.Count); i++ {
+			typ.Buckets = append(typ.Buckets, e.Start*math.Pow(e.Factor, float64(i)))
+		}
+		return typ, nil
+	}
+
+	if len(req.Buckets) > maxBuckets {
+		return nil, errors.BadRequest("analytics.declare", "too many buckets, at most %d are allowed", maxBuckets)
+	}
+	for i, b := range req.Buckets {
+		if math.IsNaN(b) || math.IsInf(b, 0) || (i > 0 && b <= req.Buckets[i-1]) {
+			return nil, errors.BadRequest("analytics.declare", "buckets must be finite and in increasing order")
+		}
+	}
+	typ.Buckets = req.Buckets
+
+	return typ, nil
+}
+
+// Declare sets the type of an event
+func (a *Analytics) Declare(ctx context.Context, req *pb.DeclareRequest, rsp *pb.DeclareResponse) error {
+	// Validate the request
+	if len(req.Name) == 0 {
+		return errors.BadRequest("analytics.declare", "missing name")
+	}
+
+	typ, err := declaredType(req)
+	if err != nil {
+		return err
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	prev, err := readType(tnt, req.Name)
+	if err != nil {
+		return errors.InternalServerError("analytics.declare", "Error reading from store: %v", err.Error())
+	}
+	if prev.equal(typ) {
+		return nil
+	}
+
+	// the records of a tracked event have the shape of its type, while the
+	// top values are only counted from when they're declared
+	if !prev.sameShape(typ) {
+		if _, err := readEvent(tnt, req.Name); err == nil {
+			return errors.BadRequest("analytics.declare", "event already tracked as a %s, delete it to change its type", prev.Type)
+		} else if err != store.ErrNotFound {
+			return errors.InternalServerError("analytics.declare", "Error reading from store: %v", err.Error())
+		}
+	}
+
+	if err := store.Write(store.NewRecord(typeKey(tnt, req.Name), typ)); err != nil {
+		return errors.InternalServerError("analytics.declare", "Error writing to store: %v", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"regexp"
+	"strings"
+)
+
+// device classes of a user agent
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+)
+
+// userAgent is what a user agent string says about the browser
+type userAgent struct {
+	browser        string
+	browserVersion string
+	os             string
+	osVersion      string
+	device         string
+}
+
+// pattern matches a user agent, capturing its version
+type pattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// browsers in the order they're matched, as most browsers also claim to
+// be the ones they're based on e.g Edge claims to be Chrome and Safari
+var browsers = []pattern{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS|Chromium)/(\d+)`)},
+	{"Safari", regexp.MustCompile(`Version/(\d+).*Safari/`)},
+	{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)(\d+)`)},
+}
+
+// operatingSystems in the order they're matched
+var operatingSystems = []pattern{
+	{"Windows", regexp.MustCompile(`Windows NT (\d+\.\d+)`)},
+	{"iOS", regexp.MustCompile(`(?:iPhone|iPad|iPod).*OS (\d+(?:_\d+)?)`)},
+	{"macOS", regexp.MustCompile(`Mac OS X (\d+(?:[_.]\d+)?)`)},
+	{"Android", regexp.MustCompile(`Android (\d+(?:\.\d+)?)`)},
+	{"Chrome OS", regexp.MustCompile(`CrOS \S+ (\d+)`)},
+	{"Linux", regexp.MustCompile(`Linux()`)},
+}
+
+// windowsVersions maps the kernel versions of Windows to its releases
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.1":  "XP",
+}
+
+var (
+	// botAgent matches the user agents of the bundled bot patterns
+	botAgent = regexp.MustCompile(`(?i)` + strings.Join(botPatterns, "|"))
+	// botName captures the name of a crawler
+	botName = regexp.MustCompile(`(?i)([a-z][\w-]*(?:bot|crawler|spider))`)
+)
+
+// botLabel returns the name of the bot using a user agent
+func botLabel(ua string) string {
+	if m := botName.FindStringSubmatch(ua); m != nil {
+		return m[1]
+	}
+	if f := strings.Fields(ua); len(f) > 0 {
+		// the product token e.g curl/7.79.1
+		return strings.SplitN(f[0], "/", 2)[0]
+	}
+	return "Other"
+}
+
+// parseUserAgent parses a user agent string locally with a few patterns
+// covering the common browsers, operating systems and crawlers
+func parseUserAgent(ua string) *userAgent {
+	u := &userAgent{
+		browser: "Other",
+		os:      "Other",
+		device:  deviceDesktop,
+	}
+
+	if botAgent.MatchString(ua) {
+		u.device = deviceBot
+		u.browser = botLabel(ua)
+		return u
+	}
+
+	for _, p := range browsers {
+		if m := p.re.FindStringSubmatch(ua); m != nil {
+			u.browser, u.browserVersion = p.name, m[1]
+			break
+		}
+	}
+
+	for _, p := range operatingSystems {
+		if m := p.re.FindStringSubmatch(ua); m != nil {
+			u.os, u.osVersion = p.name, strings.Replace(m[1], "_", ".", -1)
+			break
+		}
+	}
+	if v, ok := windowsVersions[u.osVersion]; ok && u.os == "Windows" {
+		u.osVersion = v
+	}
+
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		u.device = deviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		u.device = deviceMobile
+	}
+
+	return u
+}
+
+// properties returns the dimensions of the user agent
+func (u *userAgent) properties() map[string]string {
+	props := map[string]string{
+		"browser": u.browser,
+		"os":      u.os,
+		"device":  u.device,
+	}
+	if len(u.browserVersion) > 0 {
+		props["browser_version"] = u.browserVersion
+	}
+	if len(u.osVersion) > 0 {
+		props["os_version"] = u.osVersion
+	}
+	return props
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want userAgent
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			userAgent{"Edge", "120", "Windows", "10", deviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36 EdgA/120.0.2210.115",
+			userAgent{"Edge", "120", "Android", "14", deviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			userAgent{"Chrome", "120", "iOS", "17.2", deviceMobile},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 13; SM-S911B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			userAgent{"Samsung Internet", "23", "Android", "13", deviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			userAgent{"Safari", "16", "iOS", "16.6", deviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 12; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			userAgent{"Chrome", "120", "Android", "12", deviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			userAgent{"Chrome", "120", "Android", "14", deviceMobile},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:121.0) Gecko/20100101 Firefox/121.0",
+			userAgent{"Firefox", "121", "macOS", "10.15", deviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			userAgent{"Safari", "17", "macOS", "10.15", deviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			userAgent{"Chrome", "120", "Chrome OS", "14541", deviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 6.1; Trident/7.0; rv:11.0) like Gecko",
+			userAgent{"Internet Explorer", "11", "Windows", "7", deviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			userAgent{"Googlebot", "", "Other", "", deviceBot},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.71 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			userAgent{"Googlebot", "", "Other", "", deviceBot},
+		},
+		{
+			"",
+			userAgent{"Other", "", "Other", "", deviceDesktop},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := parseUserAgent(tt.ua); *got != tt.want {
+			t.Errorf("parseUserAgent(%q) = %+v, want %+v", tt.ua, *got, tt.want)
+		}
+	}
+}
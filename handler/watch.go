@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/store"
+	"github.com/micro/services/pkg/tenant"
+	"google.golang.org/protobuf/proto"
+
+	pb "analytics/proto"
+)
+
+// watchInterval is how often the changes of a watched event are pushed at most
+const watchInterval = time.Second
+
+// watchResync is how often every watched event is read again, which picks
+// up the changes counted by other replicas
+const watchResync = 10 * time.Second
+
+// maxWatchNames is the maximum number of events watched by name
+const maxWatchNames = 100
+
+// watcher is a Watch stream waiting for the events it watches to change
+type watcher struct {
+	tnt    string
+	names  map[string]bool
+	prefix string
+
+	lock sync.Mutex
+	// dirty holds the events changed since they were last pushed
+	dirty map[string]bool
+}
+
+// watches returns whether the watcher is interested in an event
+func (w *watcher) watches(tnt, name string) bool {
+	if tnt != w.tnt {
+		return false
+	}
+	if len(w.names) > 0 {
+		return w.names[name]
+	}
+	return strings.HasPrefix(name, w.prefix)
+}
+
+// mark records that an event changed
+func (w *watcher) mark(name string) {
+	w.lock.Lock()
+	w.dirty[name] = true
+	w.lock.Unlock()
+}
+
+// flush returns the events changed since the last flush
+func (w *watcher) flush() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	names := make([]string, 0, len(w.dirty))
+	for name := range w.dirty {
+		names = append(names, name)
+	}
+	w.dirty = map[string]bool{}
+
+	return names
+}
+
+// read returns the current value of every watched event
+func (w *watcher) read() (map[string]*pb.Event, error) {
+	events := map[string]*pb.Event{}
+
+	if len(w.names) > 0 {
+		for name := range w.names {
+			event, err := readEvent(w.tnt, name)
+			if err == store.ErrNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			events[name] = event
+		}
+		return events, nil
+	}
+
+	shards, err := readRecords(keyPrefix(kindEvent, w.tnt) + escape(w.prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, total := range sumByName(shards) {
+		events[name] = total.Event
+	}
+
+	return events, nil
+}
+
+// notify marks the events changed for every watcher interested in them
+func (a *Analytics) notify(tnt string, names ...string) {
+	a.watchLock.RLock()
+	defer a.watchLock.RUnlock()
+
+	for w := range a.watchers {
+		for _, name := range names {
+			if w.watches(tnt, name) {
+				w.mark(name)
+			}
+		}
+	}
+}
+
+// Watch streams the changes of events
+func (a *Analytics) Watch(ctx context.Context, req *pb.WatchRequest, stream pb.Analytics_WatchStream) error {
+	defer stream.Close()
+
+	// Validate the request
+	if len(req.Names) == 0 && len(req.Prefix) == 0 {
+		return errors.BadRequest("analytics.watch", "missing names or prefix")
+	}
+	if len(req.Names) > maxWatchNames {
+		return errors.BadRequest("analytics.watch", "too many names, at most %d are allowed", maxWatchNames)
+	}
+
+	tnt, ok := tenant.FromContext(ctx)
+	if !ok {
+		tnt = "default"
+	}
+
+	w := &watcher{
+		tnt:    tnt,
+		names:  map[string]bool{},
+		prefix: req.Prefix,
+		dirty:  map[string]bool{},
+	}
+	for _, name := range req.Names {
+		w.names[name] = true
+	}
+
+	a.watchLock.Lock()
+	a.watchers[w] = true
+	a.watchLock.Unlock()
+
+	defer func() {
+		a.watchLock.Lock()
+		delete(a.watchers, w)
+		a.watchLock.Unlock()
+	}()
+
+	// the values last pushed to the stream
+	sent := map[string]*pb.Event{}
+
+	push := func(events map[string]*pb.Event) error {
+		for name, event := range events {
+			if proto.Equal(event, sent[name]) {
+				continue
+			}
+			if err := stream.Send(&pb.WatchResponse{Event: event}); err != nil {
+				return err
+			}
+			sent[name] = event
+		}
+		return nil
+	}
+
+	// Push the current values first
+	events, err := w.read()
+	if err != nil {
+		return errors.InternalServerError("analytics.watch", "Error reading from store: %v", err.Error())
+	}
+	if err := push(events); err != nil {
+		return err
+	}
+
+	tick := time.NewTicker(watchInterval)
+	defer tick.Stop()
+
+	resync := time.NewTicker(watchResync)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			// push the events changed since the last tick
+			events := map[string]*pb.Event{}
+			for _, name := range w.flush() {
+				event, err := readEvent(tnt, name)
+				if err == store.ErrNotFound {
+					continue
+				} else if err != nil {
+					return errors.InternalServerError("analytics.watch", "Error reading from store: %v", err.Error())
+				}
+				events[name] = event
+			}
+			if err := push(events); err != nil {
+				return err
+			}
+		case <-resync.C:
+			events, err := w.read()
+			if err != nil {
+				return errors.InternalServerError("analytics.watch", "Error reading from store: %v", err.Error())
+			}
+			if err := push(events); err != nil {
+				return err
+			}
+		}
+	}
+}
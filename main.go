@@ -1,10 +1,15 @@
 package main
 
 import (
+	"net/http"
+	"strings"
+	"time"
+
 	"analytics/handler"
 	pb "analytics/proto"
 
 	"github.com/micro/micro/v3/service"
+	"github.com/micro/micro/v3/service/config"
 	"github.com/micro/micro/v3/service/logger"
 )
 
@@ -15,11 +20,121 @@ func main() {
 		service.Version("latest"),
 	)
 
-	h := handler.New()
+	// Rewrite records stored by previous versions
+	if err := handler.Migrate(); err != nil {
+		logger.Fatalf("Error migrating store: %v", err)
+	}
+
+	var opts []handler.Option
+
+	// Record where events come from with a local MaxMind DB file if
+	// configured, reloading it when it's replaced e.g by geoipupdate
+	if v, err := config.Get("analytics.geoip_database"); err == nil {
+		if path := v.String(""); len(path) > 0 {
+			var truncate bool
+			if t, err := config.Get("analytics.geoip_truncate"); err == nil {
+				truncate = t.Bool(false)
+			}
+
+			geo, err := handler.OpenGeoIP(path, truncate)
+			if err != nil {
+				logger.Fatalf("Error loading GeoIP database: %v", err)
+			}
+
+			interval := time.Minute
+			if i, err := config.Get("analytics.geoip_reload_interval"); err == nil {
+				interval = i.Duration(interval)
+			}
+			go geo.Watch(interval)
+
+			opts = append(opts, handler.WithGeoIP(geo))
+		}
+	}
+
+	h := handler.New(opts...)
+
+	// Record the replica is live before it writes, and fold the shards of
+	// replicas which stopped into a live one
+	if err := h.Heartbeat(); err != nil {
+		logger.Fatalf("Error recording heartbeat: %v", err)
+	}
+	compact := 10 * time.Minute
+	if v, err := config.Get("analytics.compact_interval"); err == nil {
+		compact = v.Duration(compact)
+	}
+	go h.CompactShards(compact)
 
 	// Register handler
 	pb.RegisterAnalyticsHandler(srv.Server(), h)
 
+	// End the sessions of inactive users
+	sweep := time.Minute
+	if v, err := config.Get("analytics.session_sweep_interval"); err == nil {
+		sweep = v.Duration(sweep)
+	}
+	go h.SweepSessions(sweep)
+
+	// Count the StatsD lines received on every configured address, each
+	// mapped to the tenant its lines are counted for. Lines may only pick
+	// one of the comma separated tenants allowed for their address.
+	if v, err := config.Get("analytics.statsd_listeners"); err == nil {
+		var tenantTag string
+		if t, err := config.Get("analytics.statsd_tenant_tag"); err == nil {
+			tenantTag = t.String("")
+		}
+
+		allowlist := map[string]string{}
+		if t, err := config.Get("analytics.statsd_tenant_allowlist"); err == nil {
+			allowlist = t.StringMap(allowlist)
+		}
+
+		for addr, tnt := range v.StringMap(nil) {
+			var allowed []string
+			if len(allowlist[addr]) > 0 {
+				allowed = strings.Split(allowlist[addr], ",")
+			}
+
+			go func(addr, tnt string, allowed []string) {
+				logger.Infof("Listening for StatsD on %s for %s", addr, tnt)
+				if err := h.ServeStatsD(addr, tnt, tenantTag, allowed); err != nil {
+					logger.Fatalf("Error listening for StatsD: %v", err)
+				}
+			}(addr, tnt, allowed)
+		}
+	}
+
+	// Record the events sent by browsers if configured
+	if v, err := config.Get("analytics.tracker_address"); err == nil {
+		if addr := v.String(""); len(addr) > 0 {
+			mux := http.NewServeMux()
+			tracker := handler.NewTracker(h)
+			mux.Handle("/t.gif", tracker)
+			mux.Handle("/t", tracker)
+
+			go func() {
+				logger.Infof("Serving tracker on %s", addr)
+				if err := http.ListenAndServe(addr, mux); err != nil {
+					logger.Fatalf("Error serving tracker: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Expose the events of every tenant to Prometheus if configured
+	if v, err := config.Get("analytics.metrics_address"); err == nil {
+		if addr := v.String(""); len(addr) > 0 {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", handler.NewMetrics())
+
+			go func() {
+				logger.Infof("Serving metrics on %s/metrics", addr)
+				if err := http.ListenAndServe(addr, mux); err != nil {
+					logger.Fatalf("Error serving metrics: %v", err)
+				}
+			}()
+		}
+	}
+
 	// Run service
 	if err := srv.Run(); err != nil {
 		logger.Fatal(err)
@@ -46,6 +46,23 @@ type AnalyticsService interface {
 	Read(ctx context.Context, in *ReadRequest, opts ...client.CallOption) (*ReadResponse, error)
 	Delete(ctx context.Context, in *DeleteRequest, opts ...client.CallOption) (*DeleteResponse, error)
 	List(ctx context.Context, in *ListRequest, opts ...client.CallOption) (*ListResponse, error)
+	Series(ctx context.Context, in *SeriesRequest, opts ...client.CallOption) (*SeriesResponse, error)
+	Breakdown(ctx context.Context, in *BreakdownRequest, opts ...client.CallOption) (*BreakdownResponse, error)
+	BatchTrack(ctx context.Context, in *BatchTrackRequest, opts ...client.CallOption) (*BatchTrackResponse, error)
+	Funnel(ctx context.Context, in *FunnelRequest, opts ...client.CallOption) (*FunnelResponse, error)
+	Retention(ctx context.Context, in *RetentionRequest, opts ...client.CallOption) (*RetentionResponse, error)
+	Replay(ctx context.Context, in *ReplayRequest, opts ...client.CallOption) (*ReplayResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...client.CallOption) (Analytics_WatchService, error)
+	CreateSite(ctx context.Context, in *CreateSiteRequest, opts ...client.CallOption) (*CreateSiteResponse, error)
+	ListSites(ctx context.Context, in *ListSitesRequest, opts ...client.CallOption) (*ListSitesResponse, error)
+	DeleteSite(ctx context.Context, in *DeleteSiteRequest, opts ...client.CallOption) (*DeleteSiteResponse, error)
+	PageView(ctx context.Context, in *PageViewRequest, opts ...client.CallOption) (*PageViewResponse, error)
+	Top(ctx context.Context, in *TopRequest, opts ...client.CallOption) (*TopResponse, error)
+	Filtered(ctx context.Context, in *FilteredRequest, opts ...client.CallOption) (*FilteredResponse, error)
+	Sessions(ctx context.Context, in *SessionsRequest, opts ...client.CallOption) (*SessionsResponse, error)
+	Declare(ctx context.Context, in *DeclareRequest, opts ...client.CallOption) (*DeclareResponse, error)
+	Quantiles(ctx context.Context, in *QuantilesRequest, opts ...client.CallOption) (*QuantilesResponse, error)
+	TopValues(ctx context.Context, in *TopValuesRequest, opts ...client.CallOption) (*TopValuesResponse, error)
 }
 
 type analyticsService struct {
@@ -100,6 +117,215 @@ func (c *analyticsService) List(ctx context.Context, in *ListRequest, opts ...cl
 	return out, nil
 }
 
+func (c *analyticsService) Series(ctx context.Context, in *SeriesRequest, opts ...client.CallOption) (*SeriesResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Series", in)
+	out := new(SeriesResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Breakdown(ctx context.Context, in *BreakdownRequest, opts ...client.CallOption) (*BreakdownResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Breakdown", in)
+	out := new(BreakdownResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) BatchTrack(ctx context.Context, in *BatchTrackRequest, opts ...client.CallOption) (*BatchTrackResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.BatchTrack", in)
+	out := new(BatchTrackResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Funnel(ctx context.Context, in *FunnelRequest, opts ...client.CallOption) (*FunnelResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Funnel", in)
+	out := new(FunnelResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Retention(ctx context.Context, in *RetentionRequest, opts ...client.CallOption) (*RetentionResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Retention", in)
+	out := new(RetentionResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Replay(ctx context.Context, in *ReplayRequest, opts ...client.CallOption) (*ReplayResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Replay", in)
+	out := new(ReplayResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Watch(ctx context.Context, in *WatchRequest, opts ...client.CallOption) (Analytics_WatchService, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Watch", &WatchRequest{})
+	stream, err := c.c.Stream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(in); err != nil {
+		return nil, err
+	}
+	return &analyticsServiceWatch{stream}, nil
+}
+
+type Analytics_WatchService interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Recv() (*WatchResponse, error)
+}
+
+type analyticsServiceWatch struct {
+	stream client.Stream
+}
+
+func (x *analyticsServiceWatch) Close() error {
+	return x.stream.Close()
+}
+
+func (x *analyticsServiceWatch) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *analyticsServiceWatch) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *analyticsServiceWatch) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *analyticsServiceWatch) Recv() (*WatchResponse, error) {
+	m := new(WatchResponse)
+	err := x.stream.Recv(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *analyticsService) CreateSite(ctx context.Context, in *CreateSiteRequest, opts ...client.CallOption) (*CreateSiteResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.CreateSite", in)
+	out := new(CreateSiteResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) ListSites(ctx context.Context, in *ListSitesRequest, opts ...client.CallOption) (*ListSitesResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.ListSites", in)
+	out := new(ListSitesResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) DeleteSite(ctx context.Context, in *DeleteSiteRequest, opts ...client.CallOption) (*DeleteSiteResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.DeleteSite", in)
+	out := new(DeleteSiteResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) PageView(ctx context.Context, in *PageViewRequest, opts ...client.CallOption) (*PageViewResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.PageView", in)
+	out := new(PageViewResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Top(ctx context.Context, in *TopRequest, opts ...client.CallOption) (*TopResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Top", in)
+	out := new(TopResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Filtered(ctx context.Context, in *FilteredRequest, opts ...client.CallOption) (*FilteredResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Filtered", in)
+	out := new(FilteredResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Sessions(ctx context.Context, in *SessionsRequest, opts ...client.CallOption) (*SessionsResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Sessions", in)
+	out := new(SessionsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Declare(ctx context.Context, in *DeclareRequest, opts ...client.CallOption) (*DeclareResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Declare", in)
+	out := new(DeclareResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) Quantiles(ctx context.Context, in *QuantilesRequest, opts ...client.CallOption) (*QuantilesResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.Quantiles", in)
+	out := new(QuantilesResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsService) TopValues(ctx context.Context, in *TopValuesRequest, opts ...client.CallOption) (*TopValuesResponse, error) {
+	req := c.c.NewRequest(c.name, "Analytics.TopValues", in)
+	out := new(TopValuesResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Analytics service
 
 type AnalyticsHandler interface {
@@ -107,6 +333,23 @@ type AnalyticsHandler interface {
 	Read(context.Context, *ReadRequest, *ReadResponse) error
 	Delete(context.Context, *DeleteRequest, *DeleteResponse) error
 	List(context.Context, *ListRequest, *ListResponse) error
+	Series(context.Context, *SeriesRequest, *SeriesResponse) error
+	Breakdown(context.Context, *BreakdownRequest, *BreakdownResponse) error
+	BatchTrack(context.Context, *BatchTrackRequest, *BatchTrackResponse) error
+	Funnel(context.Context, *FunnelRequest, *FunnelResponse) error
+	Retention(context.Context, *RetentionRequest, *RetentionResponse) error
+	Replay(context.Context, *ReplayRequest, *ReplayResponse) error
+	Watch(context.Context, *WatchRequest, Analytics_WatchStream) error
+	CreateSite(context.Context, *CreateSiteRequest, *CreateSiteResponse) error
+	ListSites(context.Context, *ListSitesRequest, *ListSitesResponse) error
+	DeleteSite(context.Context, *DeleteSiteRequest, *DeleteSiteResponse) error
+	PageView(context.Context, *PageViewRequest, *PageViewResponse) error
+	Top(context.Context, *TopRequest, *TopResponse) error
+	Filtered(context.Context, *FilteredRequest, *FilteredResponse) error
+	Sessions(context.Context, *SessionsRequest, *SessionsResponse) error
+	Declare(context.Context, *DeclareRequest, *DeclareResponse) error
+	Quantiles(context.Context, *QuantilesRequest, *QuantilesResponse) error
+	TopValues(context.Context, *TopValuesRequest, *TopValuesResponse) error
 }
 
 func RegisterAnalyticsHandler(s server.Server, hdlr AnalyticsHandler, opts ...server.HandlerOption) error {
@@ -115,6 +358,23 @@ func RegisterAnalyticsHandler(s server.Server, hdlr AnalyticsHandler, opts ...se
 		Read(ctx context.Context, in *ReadRequest, out *ReadResponse) error
 		Delete(ctx context.Context, in *DeleteRequest, out *DeleteResponse) error
 		List(ctx context.Context, in *ListRequest, out *ListResponse) error
+		Series(ctx context.Context, in *SeriesRequest, out *SeriesResponse) error
+		Breakdown(ctx context.Context, in *BreakdownRequest, out *BreakdownResponse) error
+		BatchTrack(ctx context.Context, in *BatchTrackRequest, out *BatchTrackResponse) error
+		Funnel(ctx context.Context, in *FunnelRequest, out *FunnelResponse) error
+		Retention(ctx context.Context, in *RetentionRequest, out *RetentionResponse) error
+		Replay(ctx context.Context, in *ReplayRequest, out *ReplayResponse) error
+		Watch(ctx context.Context, stream server.Stream) error
+		CreateSite(ctx context.Context, in *CreateSiteRequest, out *CreateSiteResponse) error
+		ListSites(ctx context.Context, in *ListSitesRequest, out *ListSitesResponse) error
+		DeleteSite(ctx context.Context, in *DeleteSiteRequest, out *DeleteSiteResponse) error
+		PageView(ctx context.Context, in *PageViewRequest, out *PageViewResponse) error
+		Top(ctx context.Context, in *TopRequest, out *TopResponse) error
+		Filtered(ctx context.Context, in *FilteredRequest, out *FilteredResponse) error
+		Sessions(ctx context.Context, in *SessionsRequest, out *SessionsResponse) error
+		Declare(ctx context.Context, in *DeclareRequest, out *DeclareResponse) error
+		Quantiles(ctx context.Context, in *QuantilesRequest, out *QuantilesResponse) error
+		TopValues(ctx context.Context, in *TopValuesRequest, out *TopValuesResponse) error
 	}
 	type Analytics struct {
 		analytics
@@ -142,3 +402,107 @@ func (h *analyticsHandler) Delete(ctx context.Context, in *DeleteRequest, out *D
 func (h *analyticsHandler) List(ctx context.Context, in *ListRequest, out *ListResponse) error {
 	return h.AnalyticsHandler.List(ctx, in, out)
 }
+
+func (h *analyticsHandler) Series(ctx context.Context, in *SeriesRequest, out *SeriesResponse) error {
+	return h.AnalyticsHandler.Series(ctx, in, out)
+}
+
+func (h *analyticsHandler) Breakdown(ctx context.Context, in *BreakdownRequest, out *BreakdownResponse) error {
+	return h.AnalyticsHandler.Breakdown(ctx, in, out)
+}
+
+func (h *analyticsHandler) BatchTrack(ctx context.Context, in *BatchTrackRequest, out *BatchTrackResponse) error {
+	return h.AnalyticsHandler.BatchTrack(ctx, in, out)
+}
+
+func (h *analyticsHandler) Funnel(ctx context.Context, in *FunnelRequest, out *FunnelResponse) error {
+	return h.AnalyticsHandler.Funnel(ctx, in, out)
+}
+
+func (h *analyticsHandler) Retention(ctx context.Context, in *RetentionRequest, out *RetentionResponse) error {
+	return h.AnalyticsHandler.Retention(ctx, in, out)
+}
+
+func (h *analyticsHandler) Replay(ctx context.Context, in *ReplayRequest, out *ReplayResponse) error {
+	return h.AnalyticsHandler.Replay(ctx, in, out)
+}
+
+func (h *analyticsHandler) Watch(ctx context.Context, stream server.Stream) error {
+	m := new(WatchRequest)
+	if err := stream.Recv(m); err != nil {
+		return err
+	}
+	return h.AnalyticsHandler.Watch(ctx, m, &analyticsWatchStream{stream})
+}
+
+type Analytics_WatchStream interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Send(*WatchResponse) error
+}
+
+type analyticsWatchStream struct {
+	stream server.Stream
+}
+
+func (x *analyticsWatchStream) Close() error {
+	return x.stream.Close()
+}
+
+func (x *analyticsWatchStream) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *analyticsWatchStream) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *analyticsWatchStream) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *analyticsWatchStream) Send(m *WatchResponse) error {
+	return x.stream.Send(m)
+}
+
+func (h *analyticsHandler) CreateSite(ctx context.Context, in *CreateSiteRequest, out *CreateSiteResponse) error {
+	return h.AnalyticsHandler.CreateSite(ctx, in, out)
+}
+
+func (h *analyticsHandler) ListSites(ctx context.Context, in *ListSitesRequest, out *ListSitesResponse) error {
+	return h.AnalyticsHandler.ListSites(ctx, in, out)
+}
+
+func (h *analyticsHandler) DeleteSite(ctx context.Context, in *DeleteSiteRequest, out *DeleteSiteResponse) error {
+	return h.AnalyticsHandler.DeleteSite(ctx, in, out)
+}
+
+func (h *analyticsHandler) PageView(ctx context.Context, in *PageViewRequest, out *PageViewResponse) error {
+	return h.AnalyticsHandler.PageView(ctx, in, out)
+}
+
+func (h *analyticsHandler) Top(ctx context.Context, in *TopRequest, out *TopResponse) error {
+	return h.AnalyticsHandler.Top(ctx, in, out)
+}
+
+func (h *analyticsHandler) Filtered(ctx context.Context, in *FilteredRequest, out *FilteredResponse) error {
+	return h.AnalyticsHandler.Filtered(ctx, in, out)
+}
+
+func (h *analyticsHandler) Sessions(ctx context.Context, in *SessionsRequest, out *SessionsResponse) error {
+	return h.AnalyticsHandler.Sessions(ctx, in, out)
+}
+
+func (h *analyticsHandler) Declare(ctx context.Context, in *DeclareRequest, out *DeclareResponse) error {
+	return h.AnalyticsHandler.Declare(ctx, in, out)
+}
+
+func (h *analyticsHandler) Quantiles(ctx context.Context, in *QuantilesRequest, out *QuantilesResponse) error {
+	return h.AnalyticsHandler.Quantiles(ctx, in, out)
+}
+
+func (h *analyticsHandler) TopValues(ctx context.Context, in *TopValuesRequest, out *TopValuesResponse) error {
+	return h.AnalyticsHandler.TopValues(ctx, in, out)
+}